@@ -0,0 +1,180 @@
+package models
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthPoolVersion 当前用户池文件格式版本，v1为无表头的 {phone},{authorization}
+const AuthPoolVersion = 2
+
+const authPoolVersionPrefix = "#hmdp-auth-pool,v"
+
+var authPoolHeader = []string{"user_id", "phone", "token", "created_at", "expires_at"}
+
+// AuthEntry 用户池中的一个用户，UserID为0或时间为零值表示未知
+type AuthEntry struct {
+	UserID    int
+	Phone     string
+	Token     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (e AuthEntry) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+func (e AuthEntry) User() User {
+	return User{ID: e.UserID, Phone: e.Phone, Auth: e.Token}
+}
+
+// AuthPoolReader 逐行读取用户池文件，兼容v1格式
+type AuthPoolReader struct {
+	reader  *csv.Reader
+	version int
+}
+
+func NewAuthPoolReader(r io.Reader) (*AuthPoolReader, error) {
+	br := bufio.NewReader(r)
+	version := 1
+	first, err := br.Peek(1)
+	if err == nil && first[0] == '#' {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, authPoolVersionPrefix) {
+			return nil, fmt.Errorf("invalid auth pool version line: %q", line)
+		}
+		version, err = strconv.Atoi(strings.TrimPrefix(line, authPoolVersionPrefix))
+		if err != nil || version > AuthPoolVersion {
+			return nil, fmt.Errorf("unsupported auth pool version: %q", line)
+		}
+	}
+	reader := csv.NewReader(br)
+	reader.ReuseRecord = true
+	if version >= 2 {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read auth pool header: %w", err)
+		}
+		if strings.Join(header, ",") != strings.Join(authPoolHeader, ",") {
+			return nil, fmt.Errorf("unexpected auth pool header: %v", header)
+		}
+	}
+	return &AuthPoolReader{reader: reader, version: version}, nil
+}
+
+func (r *AuthPoolReader) Version() int {
+	return r.version
+}
+
+// Read 读取下一个用户，读完时返回io.EOF
+func (r *AuthPoolReader) Read() (AuthEntry, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return AuthEntry{}, err
+	}
+	if r.version == 1 {
+		if len(record) < 2 {
+			return AuthEntry{}, fmt.Errorf("invalid auth record %v, expected format : {phone},{authorization}", record)
+		}
+		return AuthEntry{Phone: record[0], Token: record[1]}, nil
+	}
+	if len(record) != len(authPoolHeader) {
+		return AuthEntry{}, fmt.Errorf("invalid auth record %v, expected columns: %v", record, authPoolHeader)
+	}
+	entry := AuthEntry{Phone: record[1], Token: record[2]}
+	if record[0] != "" {
+		if entry.UserID, err = strconv.Atoi(record[0]); err != nil {
+			return AuthEntry{}, fmt.Errorf("invalid user_id %q: %w", record[0], err)
+		}
+	}
+	if entry.CreatedAt, err = parsePoolTime(record[3]); err != nil {
+		return AuthEntry{}, err
+	}
+	if entry.ExpiresAt, err = parsePoolTime(record[4]); err != nil {
+		return AuthEntry{}, err
+	}
+	return entry, nil
+}
+
+// LoadAuthPool 流式读取用户池，对每个用户调用fn，不在内存中保存整个用户池
+func LoadAuthPool(r io.Reader, fn func(AuthEntry) error) error {
+	reader, err := NewAuthPoolReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// AuthPoolWriter 以当前版本格式写入用户池
+type AuthPoolWriter struct {
+	writer *csv.Writer
+}
+
+func NewAuthPoolWriter(w io.Writer) (*AuthPoolWriter, error) {
+	if _, err := io.WriteString(w, authPoolVersionPrefix+strconv.Itoa(AuthPoolVersion)+"\n"); err != nil {
+		return nil, err
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(authPoolHeader); err != nil {
+		return nil, err
+	}
+	return &AuthPoolWriter{writer: writer}, nil
+}
+
+func (w *AuthPoolWriter) Write(entry AuthEntry) error {
+	userId := ""
+	if entry.UserID != 0 {
+		userId = strconv.Itoa(entry.UserID)
+	}
+	return w.writer.Write([]string{
+		userId,
+		entry.Phone,
+		entry.Token,
+		formatPoolTime(entry.CreatedAt),
+		formatPoolTime(entry.ExpiresAt),
+	})
+}
+
+func (w *AuthPoolWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func parsePoolTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return t, nil
+}
+
+func formatPoolTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
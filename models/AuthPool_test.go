@@ -0,0 +1,76 @@
+package models
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAllAuths(t *testing.T, r io.Reader) ([]AuthEntry, int) {
+	t.Helper()
+	reader, err := NewAuthPoolReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]AuthEntry, 0)
+	for {
+		entry, err := reader.Read()
+		if err == io.EOF {
+			return entries, reader.Version()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestAuthPoolRoundTrip(t *testing.T) {
+	created := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	entries := []AuthEntry{
+		{UserID: 1010, Phone: "18000000000", Token: "token-a", CreatedAt: created, ExpiresAt: created.Add(24 * time.Hour)},
+		// 未知的用户ID和时间写为空
+		{Phone: "18000000001", Token: "token,with\"quote"},
+	}
+	var buf bytes.Buffer
+	writer, err := NewAuthPoolWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		assert.NoError(t, writer.Write(entry))
+	}
+	assert.NoError(t, writer.Flush())
+	assert.True(t, strings.HasPrefix(buf.String(), "#hmdp-auth-pool,v2\nuser_id,phone,token,created_at,expires_at\n"))
+
+	read, version := readAllAuths(t, &buf)
+	assert.Equal(t, AuthPoolVersion, version)
+	if assert.Len(t, read, 2) {
+		assert.Equal(t, entries[0].UserID, read[0].UserID)
+		assert.True(t, entries[0].CreatedAt.Equal(read[0].CreatedAt))
+		assert.True(t, entries[0].ExpiresAt.Equal(read[0].ExpiresAt))
+		assert.Equal(t, entries[1], read[1])
+	}
+	assert.True(t, read[0].Expired(created.Add(25*time.Hour)))
+	assert.False(t, read[1].Expired(created.Add(25*time.Hour)))
+}
+
+func TestAuthPoolReadV1(t *testing.T) {
+	read, version := readAllAuths(t, strings.NewReader("18000000000,token-a\n18000000001,token-b\n"))
+	assert.Equal(t, 1, version)
+	assert.Equal(t, []AuthEntry{{Phone: "18000000000", Token: "token-a"}, {Phone: "18000000001", Token: "token-b"}}, read)
+}
+
+func TestAuthPoolRejectsBadHeader(t *testing.T) {
+	for _, content := range []string{
+		"#hmdp-auth-pool,v2\nphone,token\n",
+		"#hmdp-auth-pool,v9\nuser_id,phone,token,created_at,expires_at\n",
+		"#other-file,v2\n",
+	} {
+		_, err := NewAuthPoolReader(strings.NewReader(content))
+		assert.Error(t, err, content)
+	}
+}
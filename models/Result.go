@@ -3,8 +3,9 @@ package models
 import "encoding/json"
 
 type Result struct {
-	Success bool         `json:"success"`
-	Data    DataAsString `json:"data"`
+	Success  bool         `json:"success"`
+	ErrorMsg string       `json:"errorMsg"`
+	Data     DataAsString `json:"data"`
 }
 
 type DataAsString string
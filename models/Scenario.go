@@ -0,0 +1,166 @@
+package models
+
+import "fmt"
+
+// SessionEndpoint 会话结果在指标中的接口标签，SLO中可以用它检查会话成功率和完成时间
+const SessionEndpoint = "session"
+
+// Scenario 声明式的压测场景：准备数据、按阶段施压、校验结果和SLO、清理
+type Scenario struct {
+	Name     string           `mapstructure:"name"`
+	Setup    ScenarioSetup    `mapstructure:"setup"`
+	Phases   []ScenarioPhase  `mapstructure:"phases"`
+	Verify   ScenarioVerify   `mapstructure:"verify"`
+	Teardown ScenarioTeardown `mapstructure:"teardown"`
+}
+
+type ScenarioSetup struct {
+	// 从用户池中随机选取的用户数，0表示全部
+	Users int `mapstructure:"users"`
+	// 运行前校验token并重新登录过期用户
+	RefreshTokens bool            `mapstructure:"refresh_tokens"`
+	Voucher       ScenarioVoucher `mapstructure:"voucher"`
+	// 清理订单、消息和Redis购买记录并恢复库存
+	ResetStock bool `mapstructure:"reset_stock"`
+}
+
+type ScenarioVoucher struct {
+	// 为空时按 test.voucher.provision.template 创建新券
+	ID    string `mapstructure:"id"`
+	Stock int    `mapstructure:"stock"`
+	// 浏览请求访问的店铺，0表示使用模板中的shop_id
+	ShopID int64 `mapstructure:"shop_id"`
+}
+
+type ScenarioPhase struct {
+	Name        string `mapstructure:"name"`
+	DurationSec int    `mapstructure:"duration_sec"`
+	// 每秒请求数，0表示不限速
+	Rate        float64 `mapstructure:"rate"`
+	Concurrency int     `mapstructure:"concurrency"`
+	// 配置了思考时间时按虚拟用户运行：每个用户循环发出请求并在两次请求之间等待，Rate和Concurrency不生效
+	ThinkTime *ThinkTimeConfig `mapstructure:"think_time"`
+	// 虚拟用户数，0表示setup选出的所有用户
+	Users int               `mapstructure:"users"`
+	Mix   []ScenarioRequest `mapstructure:"mix"`
+	// 配置了会话脚本时每个虚拟用户按脚本执行一次会话，不使用Mix，DurationSec为会话的最长时间
+	Session *SessionScript `mapstructure:"session"`
+}
+
+// SessionScript 一个用户会话依次执行的步骤，如 登录 → 浏览 → 重试抢购N次 → 放弃
+type SessionScript struct {
+	Steps []SessionStep `mapstructure:"steps"`
+}
+
+// SessionStep 会话中的一步，重复执行Repeat次
+type SessionStep struct {
+	Endpoint string `mapstructure:"endpoint"`
+	// 执行次数，0按1次
+	Repeat int `mapstructure:"repeat"`
+	// 成功后进入下一步，用完次数仍未成功时放弃整个会话
+	UntilSuccess bool `mapstructure:"until_success"`
+}
+
+// ScenarioRequest 请求组合中的一类请求及其权重
+type ScenarioRequest struct {
+	Endpoint string  `mapstructure:"endpoint"`
+	Weight   float64 `mapstructure:"weight"`
+}
+
+type ScenarioVerify struct {
+	OnePersonOneOrder bool `mapstructure:"one_person_one_order"`
+	// 成功数不超过库存
+	NoOversell bool          `mapstructure:"no_oversell"`
+	SLOs       []ScenarioSLO `mapstructure:"slo"`
+}
+
+// ScenarioSLO 一个接口的服务目标，Phase为空时统计所有阶段，未填写的阈值不检查
+type ScenarioSLO struct {
+	Endpoint       string   `mapstructure:"endpoint"`
+	Phase          string   `mapstructure:"phase"`
+	P50Ms          *float64 `mapstructure:"p50_ms"`
+	P99Ms          *float64 `mapstructure:"p99_ms"`
+	MaxErrorRate   *float64 `mapstructure:"max_error_rate"`
+	MinSuccessRate *float64 `mapstructure:"min_success_rate"`
+	MinSuccess     *uint64  `mapstructure:"min_success"`
+	MaxSuccess     *uint64  `mapstructure:"max_success"`
+}
+
+type ScenarioTeardown struct {
+	// 再次清理订单、消息和Redis并恢复库存
+	ResetStock bool `mapstructure:"reset_stock"`
+	// 删除setup中创建的券
+	DeleteVoucher bool `mapstructure:"delete_voucher"`
+}
+
+// Validate 检查场景的必填项，endpoints为支持的请求类型
+func (s *Scenario) Validate(endpoints map[string]bool) error {
+	if s.Name == "" {
+		return fmt.Errorf("scenario name is required")
+	}
+	if len(s.Phases) == 0 {
+		return fmt.Errorf("scenario %s has no phases", s.Name)
+	}
+	voucher := s.Setup.Voucher
+	if voucher.Stock <= 0 && (voucher.ID == "" || s.Setup.ResetStock || s.Teardown.ResetStock || s.Verify.NoOversell) {
+		return fmt.Errorf("setup.voucher.stock must be positive")
+	}
+	phases := make(map[string]bool)
+	for i, phase := range s.Phases {
+		if phase.Name == "" {
+			return fmt.Errorf("phase %d has no name", i)
+		}
+		if phases[phase.Name] {
+			return fmt.Errorf("duplicate phase %s", phase.Name)
+		}
+		phases[phase.Name] = true
+		if phase.DurationSec <= 0 {
+			return fmt.Errorf("phase %s: duration_sec must be positive", phase.Name)
+		}
+		if phase.Rate < 0 {
+			return fmt.Errorf("phase %s: rate must not be negative", phase.Name)
+		}
+		if phase.ThinkTime != nil {
+			if phase.Rate > 0 {
+				return fmt.Errorf("phase %s: rate and think_time can not be used together", phase.Name)
+			}
+			if err := phase.ThinkTime.Validate(); err != nil {
+				return fmt.Errorf("phase %s: %w", phase.Name, err)
+			}
+		}
+		if phase.Session != nil {
+			if len(phase.Mix) > 0 || phase.Rate > 0 {
+				return fmt.Errorf("phase %s: session can not be used with mix or rate", phase.Name)
+			}
+			if len(phase.Session.Steps) == 0 {
+				return fmt.Errorf("phase %s: session has no steps", phase.Name)
+			}
+			for _, step := range phase.Session.Steps {
+				if !endpoints[step.Endpoint] {
+					return fmt.Errorf("phase %s: unknown endpoint %q", phase.Name, step.Endpoint)
+				}
+				if step.Repeat < 0 {
+					return fmt.Errorf("phase %s: repeat must not be negative", phase.Name)
+				}
+			}
+			continue
+		}
+		if len(phase.Mix) == 0 {
+			return fmt.Errorf("phase %s has no request mix", phase.Name)
+		}
+		for _, request := range phase.Mix {
+			if !endpoints[request.Endpoint] {
+				return fmt.Errorf("phase %s: unknown endpoint %q", phase.Name, request.Endpoint)
+			}
+		}
+	}
+	for _, slo := range s.Verify.SLOs {
+		if !endpoints[slo.Endpoint] && slo.Endpoint != SessionEndpoint {
+			return fmt.Errorf("slo: unknown endpoint %q", slo.Endpoint)
+		}
+		if slo.Phase != "" && !phases[slo.Phase] {
+			return fmt.Errorf("slo: unknown phase %q", slo.Phase)
+		}
+	}
+	return nil
+}
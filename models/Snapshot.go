@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// SnapshotVersion 快照文件格式版本，v2增加了二进制值的base64编码，v1的文件仍然可以读取
+const SnapshotVersion = 2
+
+// Snapshot 一次运行前后需要恢复的数据库和Redis状态
+type Snapshot struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Tables    []TableSnapshot `json:"tables"`
+	Redis     RedisSnapshot   `json:"redis"`
+}
+
+// TableSnapshot 一张表中满足Where的所有行，Where为空表示整张表。
+// 所有值按MySQL文本格式保存，nil表示NULL；不是合法UTF-8的二进制值按base64保存，并在Binary中记录 [行, 列]
+type TableSnapshot struct {
+	Name    string      `json:"name"`
+	Where   string      `json:"where"`
+	Columns []string    `json:"columns"`
+	Rows    [][]*string `json:"rows"`
+	Binary  [][2]int    `json:"binary,omitempty"`
+}
+
+// RedisSnapshot 匹配Patterns的所有key，恢复时先删除当前匹配的key再写回
+type RedisSnapshot struct {
+	Patterns []string           `json:"patterns"`
+	Keys     []RedisKeySnapshot `json:"keys"`
+}
+
+// RedisKeySnapshot DUMP得到的序列化值（base64）和剩余过期时间，TTLMillis为0表示不过期
+type RedisKeySnapshot struct {
+	Key       string `json:"key"`
+	TTLMillis int64  `json:"ttlMillis"`
+	Dump      string `json:"dump"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "fmt"
+
+// 思考时间分布
+const (
+	ThinkTimeConstant    = "constant"
+	ThinkTimeUniform     = "uniform"
+	ThinkTimeExponential = "exponential"
+	ThinkTimeNormal      = "normal"
+)
+
+// ThinkTimeConfig 思考时间分布的配置，单位毫秒：
+// constant 固定为Ms；uniform 在[MinMs, MaxMs]之间均匀分布；
+// exponential 均值为MeanMs；normal 均值为MeanMs、标准差为StddevMs。
+// exponential和normal的结果限制在[MinMs, MaxMs]之间，MaxMs为0表示没有上界
+type ThinkTimeConfig struct {
+	// 为空时按uniform
+	Distribution string `mapstructure:"distribution"`
+	Ms           int    `mapstructure:"ms"`
+	MinMs        int    `mapstructure:"min_ms"`
+	MaxMs        int    `mapstructure:"max_ms"`
+	MeanMs       int    `mapstructure:"mean_ms"`
+	StddevMs     int    `mapstructure:"stddev_ms"`
+}
+
+func (c ThinkTimeConfig) Validate() error {
+	if c.Ms < 0 || c.MinMs < 0 || c.MaxMs < 0 || c.MeanMs < 0 || c.StddevMs < 0 {
+		return fmt.Errorf("think_time values must not be negative")
+	}
+	bounded := c.MaxMs == 0 || c.MaxMs >= c.MinMs
+	switch c.Distribution {
+	case ThinkTimeConstant:
+	case "", ThinkTimeUniform:
+		if c.MaxMs < c.MinMs {
+			return fmt.Errorf("think_time: max_ms %d < min_ms %d", c.MaxMs, c.MinMs)
+		}
+	case ThinkTimeExponential:
+		if c.MeanMs == 0 || !bounded {
+			return fmt.Errorf("think_time: exponential needs mean_ms and min_ms <= max_ms")
+		}
+	case ThinkTimeNormal:
+		if c.MeanMs == 0 || !bounded {
+			return fmt.Errorf("think_time: normal needs mean_ms and min_ms <= max_ms")
+		}
+	default:
+		return fmt.Errorf("unknown think_time distribution %q", c.Distribution)
+	}
+	return nil
+}
@@ -3,9 +3,11 @@ package models
 import "fmt"
 
 type User struct {
-	ID    int    `json:"id"`
-	Phone string `json:"phone"`
-	Auth  string `json:"auth"`
+	ID       int    `json:"id"`
+	Phone    string `json:"phone"`
+	NickName string `json:"nickName"`
+	Icon     string `json:"icon"`
+	Auth     string `json:"auth"`
 }
 
 func (u User) String() string {
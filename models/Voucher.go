@@ -0,0 +1,30 @@
+package models
+
+// SeckillVoucher 一张参与压测的秒杀券及其压测参数
+type SeckillVoucher struct {
+	ID    string `mapstructure:"id"`
+	Stock int    `mapstructure:"stock"`
+	// 该券的最大并发用户数
+	MaxConcurrency int `mapstructure:"max_concurrency"`
+	// 每个用户同时并发发送的购买请求数
+	RequestsPerUser int `mapstructure:"requests_per_user"`
+	// 参与抢购的用户数，0表示按UserWeight从用户池中抽取
+	UserCount int `mapstructure:"user_count"`
+	// 参与抢购的用户占用户池的比例，UserCount和UserWeight都为0时全部用户参与
+	UserWeight float64 `mapstructure:"user_weight"`
+	// 持续抢购时同一用户两次请求之间的思考时间
+	ThinkTime ThinkTimeConfig `mapstructure:"think_time"`
+}
+
+// VoucherTemplate 自动创建秒杀券时使用的模板，开始和结束时间相对于创建时刻
+type VoucherTemplate struct {
+	ShopID         int64  `mapstructure:"shop_id"`
+	Title          string `mapstructure:"title"`
+	SubTitle       string `mapstructure:"sub_title"`
+	Rules          string `mapstructure:"rules"`
+	PayValue       int64  `mapstructure:"pay_value"`
+	ActualValue    int64  `mapstructure:"actual_value"`
+	Type           int    `mapstructure:"type"`
+	BeginOffsetSec int    `mapstructure:"begin_offset_sec"`
+	EndOffsetSec   int    `mapstructure:"end_offset_sec"`
+}
@@ -2,17 +2,16 @@ package tests
 
 import (
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"github.com/go-resty/resty/v2"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"hmdp-go-test/models"
-	"os"
+	"hmdp-go-test/utils"
+	"sort"
 	"strconv"
 	"sync"
-	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -50,11 +49,7 @@ func sendAndQueryCode(phone int64) (error, string) {
 }
 
 func getAuthWithPhoneAndCode(phone int64, code string) (error, string) {
-	payload := map[string]interface{}{
-		"phone": strconv.FormatInt(phone, 10),
-		"code":  code,
-	}
-	resp, err := HttpClient.R().SetBody(payload).Post(LoginUrl)
+	resp, err := login(phone, code)
 	if err != nil {
 		return err, ""
 	}
@@ -63,6 +58,9 @@ func getAuthWithPhoneAndCode(phone int64, code string) (error, string) {
 	if err != nil {
 		return err, ""
 	}
+	if !result.Success {
+		return fmt.Errorf("login failed: %s", result.ErrorMsg), ""
+	}
 	return err, string(result.Data)
 }
 
@@ -78,6 +76,26 @@ func getAuthWithPhone(phone int64) (error, string) {
 	return err, auth
 }
 
+// getAuthWithPhoneRecorded 与getAuthWithPhone相同，同时把发送验证码和登录请求记入统计
+func getAuthWithPhoneRecorded(phone int64, sendCodeStats, loginStats *utils.RequestStats) (error, string) {
+	if _, ok := recordApiCall(sendCodeStats, func() (*resty.Response, error) {
+		return sendCode(phone)
+	}); !ok {
+		return fmt.Errorf("send code failed"), ""
+	}
+	err, code := queryCode(phone)
+	if err != nil {
+		return err, ""
+	}
+	result, ok := recordApiCall(loginStats, func() (*resty.Response, error) {
+		return login(phone, code)
+	})
+	if !ok {
+		return fmt.Errorf("login failed: %s", result.ErrorMsg), ""
+	}
+	return nil, string(result.Data)
+}
+
 func TestLogin(t *testing.T) {
 	phone := int64(18000000000)
 	err, code := sendAndQueryCode(phone)
@@ -93,15 +111,23 @@ func TestSendCodeMulti(t *testing.T) {
 	testCount := viper.GetInt("test.user.user_count")
 	var wg sync.WaitGroup
 	errChan := make(chan error, testCount) // 带缓冲的通道防止阻塞
+	registry := utils.NewMetricsRegistry()
+	stats := registry.Stats(utils.Labels{Scenario: "send code multi", Endpoint: "send code"})
 
 	wg.Add(testCount)
+	stats.StartTime = time.Now()
 
 	for i := 0; i < testCount; i++ {
 		go func(phoneOffset int) {
 			defer wg.Done()
 			// 发送请求
 			phone := basePhone + int64(phoneOffset)
-			response, err := sendCode(phone)
+			var response *resty.Response
+			var err error
+			recordApiCall(stats, func() (*resty.Response, error) {
+				response, err = sendCode(phone)
+				return response, err
+			})
 			if err != nil {
 				errChan <- fmt.Errorf("请求失败 phone=%v: %v", phone, err)
 				return
@@ -131,57 +157,167 @@ func TestSendCodeMulti(t *testing.T) {
 	for err := range errChan {
 		t.Error(err)
 	}
+	stats.EndTime = time.Now()
+	fmt.Println(stats)
 }
 
 func TestGenerateAuths(t *testing.T) {
 	basePhone := viper.GetInt64("test.user.base_phone")
 	expectedAuthsCount := viper.GetInt("test.user.user_count")
-	var wg sync.WaitGroup
-	errChan := make(chan error, expectedAuthsCount)
-	authChan := make(chan []string, expectedAuthsCount) // 用于传输手机号和auth的通道
-	successCount := atomic.Int64{}
-	// 打开文件，使用追加模式
-	file, err := os.OpenFile(AuthsFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	batchSize := viper.GetInt("test.user.batch_size")
+	retries := viper.GetInt("test.user.max_retries")
+	backoff := time.Duration(viper.GetInt("test.user.retry_backoff_ms")) * time.Millisecond
+
+	// 读取已有的auth文件，已经有可用token的手机号直接跳过
+	pool, err := utils.ReadAuthPool(AuthsFilePath)
 	if err != nil {
-		t.Fatalf("无法打开文件: %v", err)
-	}
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-	// 启动生成goroutine
-	wg.Add(expectedAuthsCount)
-	for i := 0; i < expectedAuthsCount; {
-		for j := 0; j < viper.GetInt("test.user.batch_size") && i < expectedAuthsCount; j++ {
-			go func(phoneOffset int) {
+		t.Fatalf("无法读取文件: %v", err)
+	}
+	now := time.Now()
+	pending := make([]int64, 0, expectedAuthsCount)
+	for i := 0; i < expectedAuthsCount; i++ {
+		phone := basePhone + int64(i)
+		entry := pool[strconv.FormatInt(phone, 10)]
+		if !utils.IsValidAuth(entry.Token) || entry.Expired(now) {
+			pending = append(pending, phone)
+		}
+	}
+	skipped := expectedAuthsCount - len(pending)
+
+	registry := utils.NewMetricsRegistry()
+	sendCodeStats := registry.Stats(utils.Labels{Scenario: "generate auths", Endpoint: "send code"})
+	loginStats := registry.Stats(utils.Labels{Scenario: "generate auths", Endpoint: "login"})
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make([]int64, 0)
+	generated := make([]string, 0, len(pending))
+	sendCodeStats.StartTime, loginStats.StartTime = time.Now(), time.Now()
+	for i := 0; i < len(pending); {
+		for j := 0; j < batchSize && i < len(pending); j++ {
+			wg.Add(1)
+			go func(phone int64) {
 				defer wg.Done()
-				phone := basePhone + int64(phoneOffset)
-				err, auth := getAuthWithPhone(phone)
+				var auth string
+				err := utils.RetryWithBackoff(retries, backoff, func() error {
+					var err error
+					err, auth = getAuthWithPhoneRecorded(phone, sendCodeStats, loginStats)
+					return err
+				})
+				mu.Lock()
+				defer mu.Unlock()
 				if err != nil {
-					errChan <- fmt.Errorf("获取auth失败（手机号: %d）: %w", phone, err)
+					t.Logf("获取auth失败（手机号: %d）: %v", phone, err)
+					failed = append(failed, phone)
 					return
 				}
-				successCount.Add(1)
-				authChan <- []string{strconv.FormatInt(phone, 10), auth}
-			}(i)
+				phoneString := strconv.FormatInt(phone, 10)
+				generated = append(generated, phoneString)
+				pool[phoneString] = models.AuthEntry{
+					UserID:    pool[phoneString].UserID,
+					Phone:     phoneString,
+					Token:     auth,
+					CreatedAt: time.Now(),
+				}
+			}(pending[i])
 			i++
 		}
 		time.Sleep(1 * time.Second)
 	}
 	wg.Wait()
-	// 处理authChan，保存到auths.csv文件
-	size := len(authChan)
-	for authData := range authChan {
-		err := writer.Write(authData)
-		if err != nil {
-			fmt.Printf("write auth err, data: %v, error : %v\n", authData, err)
+	sendCodeStats.EndTime, loginStats.EndTime = time.Now(), time.Now()
+	setAuthExpiry(t, pool, generated)
+
+	// 无论是否有失败都写回文件，重新运行时只会补齐缺失的手机号
+	if err := utils.WriteAuthPoolAtomic(AuthsFilePath, pool); err != nil {
+		t.Fatalf("写入文件失败: %v", err)
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i] < failed[j] })
+	fmt.Println(sendCodeStats)
+	fmt.Println(loginStats)
+	fmt.Println(registry.Report(utils.Labels{Scenario: "generate auths"}, utils.LabelEndpoint, utils.LabelOutcome))
+	fmt.Printf("auths generated: %d, skipped: %d, failed: %d\n", len(generated), skipped, len(failed))
+	if len(failed) > 0 {
+		fmt.Printf("failed phones: %v\n", failed)
+	}
+	assert.Empty(t, failed)
+}
+
+// queryUserIds 查询用户池中用户的ID，优先使用用户池中已有的ID，按配置把查到的ID缓存回用户池
+func queryUserIds(t *testing.T, phoneToAuth map[string]string) []models.User {
+	t.Helper()
+	pool, err := utils.ReadAuthPool(AuthsFilePath)
+	if err != nil {
+		t.Fatalf("Failed to parse AuthsFile: %v", err)
+	}
+	selected := make(map[string]models.AuthEntry, len(phoneToAuth))
+	for phone, auth := range phoneToAuth {
+		entry := pool[phone]
+		entry.Phone = phone
+		entry.Token = auth
+		selected[phone] = entry
+	}
+	lookup := utils.NewUserLookup(DBClient, viper.GetInt("test.user.lookup.chunk_size"), viper.GetInt("test.user.lookup.concurrency"))
+	users, updated, err := lookup.LookupPool(context.Background(), selected)
+	if err != nil {
+		t.Fatalf("query users error: %v", err)
+	}
+	if updated && viper.GetBool("test.user.lookup.cache_in_pool") {
+		for phone, entry := range selected {
+			if _, ok := pool[phone]; ok {
+				pool[phone] = entry
+			}
 		}
-		size--
-		if size == 0 {
-			close(authChan)
+		if err := utils.WriteAuthPoolAtomic(AuthsFilePath, pool); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
 		}
 	}
-	assert.GreaterOrEqual(t, expectedAuthsCount, int(successCount.Load()))
-	assert.Equal(t, expectedAuthsCount, int(successCount.Load()))
+	return users
+}
+
+// TestLookupUserIds 分批查询用户池中缺少ID的用户，test.user.lookup.cache_in_pool 开启时写回用户池
+func TestLookupUserIds(t *testing.T) {
+	phoneToAuth := getPhonesAndAuths(t)
+	start := time.Now()
+	users := queryUserIds(t, phoneToAuth)
+	fmt.Printf("users found: %d/%d in %v\n", len(users), len(phoneToAuth), time.Since(start).Round(time.Millisecond))
+	assert.Len(t, users, len(phoneToAuth))
+}
+
+// TestProvisionAuthsDirect 直接写入tb_user和Redis登录token生成用户池，不经过短信登录接口
+func TestProvisionAuthsDirect(t *testing.T) {
+	requireDestructive(t)
+	basePhone := viper.GetInt64("test.user.base_phone")
+	userCount := viper.GetInt("test.user.user_count")
+	phones := make([]string, userCount)
+	for i := range phones {
+		phones[i] = strconv.FormatInt(basePhone+int64(i), 10)
+	}
+	start := time.Now()
+	ttl := time.Duration(viper.GetInt("test.user.provision.token_ttl_sec")) * time.Second
+	users, err := utils.ProvisionUsers(context.Background(), DBClient, RedisClient, DestructiveGuard, phones,
+		viper.GetString("test.user.provision.nick_name_prefix"),
+		viper.GetString("test.user.token.redis_prefix"),
+		ttl,
+		viper.GetInt("test.user.provision.chunk_size"))
+	if err != nil {
+		t.Fatalf("provision users failed: %v", err)
+	}
+
+	pool, err := utils.ReadAuthPool(AuthsFilePath)
+	if err != nil {
+		t.Fatalf("无法读取文件: %v", err)
+	}
+	now := time.Now()
+	for _, user := range users {
+		entry := models.AuthEntry{UserID: user.ID, Phone: user.Phone, Token: user.Auth, CreatedAt: now}
+		if ttl > 0 {
+			entry.ExpiresAt = now.Add(ttl)
+		}
+		pool[user.Phone] = entry
+	}
+	if err := utils.WriteAuthPoolAtomic(AuthsFilePath, pool); err != nil {
+		t.Fatalf("写入文件失败: %v", err)
+	}
+	fmt.Printf("provisioned %d users in %v\n", len(users), time.Since(start))
+	assert.Equal(t, userCount, len(users))
 }
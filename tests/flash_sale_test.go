@@ -3,7 +3,6 @@ package tests
 import (
 	"context"
 	"database/sql"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"github.com/go-resty/resty/v2"
@@ -12,128 +11,91 @@ import (
 	"hmdp-go-test/models"
 	"hmdp-go-test/utils"
 	"io"
-	"os"
+	"math/rand"
+	"sort"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 )
 
-func queryUserIds(t *testing.T, phoneToAuth map[string]string) []models.User {
-	phones := make([]interface{}, 0, len(phoneToAuth))
-	for phone := range phoneToAuth {
-		phones = append(phones, phone)
-	}
-	placeholder := ""
-	for i := range len(phones) {
-		if i > 0 {
-			placeholder += ", "
-		}
-		placeholder += "?"
+func getPhonesAndAuths(t *testing.T) map[string]string {
+	t.Helper()
+	// 开启后在读取用户池前校验token并重新登录过期用户
+	if viper.GetBool("test.user.token.validate_before_run") {
+		refreshAuths(t)
 	}
-	query := fmt.Sprintf("select id, phone from tb_user where phone in (%s)", placeholder)
-	result, err := DBClient.Query(query, phones...)
-	defer func(result *sql.Rows) {
-		err := result.Close()
-		if err != nil {
-			t.Fatalf("failed to close rows: %v", err)
-		}
-	}(result)
+	phoneToAuth, err := utils.ReadAuths(AuthsFilePath)
 	if err != nil {
-		t.Fatalf("query users error: %s", err.Error())
+		t.Fatalf("Failed to parse AuthsFile: %v", err)
 	}
+	if len(phoneToAuth) == 0 {
+		t.Fatalf("AuthsFile is empty or missing: %s", AuthsFilePath)
+	}
+	return phoneToAuth
+}
 
-	users := make([]models.User, 0, len(phoneToAuth))
-	for result.Next() {
-		var user models.User
-		if err := result.Scan(&user.ID, &user.Phone); err != nil {
-			t.Fatalf("failed to scan row: %s", err.Error())
-		}
-		user.Auth = phoneToAuth[user.Phone]
-		users = append(users, user)
+// redisCleanupPatterns 展开 test.redis_cleanup.patterns 中的券变量
+func redisCleanupPatterns(voucherId string) []string {
+	patterns := viper.GetStringSlice("test.redis_cleanup.patterns")
+	for i, pattern := range patterns {
+		patterns[i] = utils.ExpandKeyPattern(pattern, map[string]string{"voucher": voucherId})
 	}
-	if err := result.Err(); err != nil {
-		t.Fatalf("query users error: %s", err.Error())
+	return patterns
+}
+
+// requireRedisCleanup 压测前需要真正清理Redis并恢复库存，dry run时跳过，
+// 否则MySQL被重置而Redis没有，压测从不一致的数据开始
+func requireRedisCleanup(t *testing.T) {
+	t.Helper()
+	if viper.GetBool("test.redis_cleanup.dry_run") {
+		t.Skip("test.redis_cleanup.dry_run is on, use TestCleanRedis to preview the cleanup")
 	}
-	return users
 }
 
-func getPhonesAndAuths(t *testing.T) map[string]string {
+// cleanRedisDatabase 按 test.redis_cleanup 配置清理购买记录和限流key并恢复Redis库存，调用前需要 requireRedisCleanup
+func cleanRedisDatabase(t *testing.T, voucherId string, stock int) {
 	t.Helper()
-	file, err := os.Open(AuthsFilePath)
-	if err != nil {
-		t.Fatalf("Failed to open AuthsFile: %v", err)
-		return nil
+	if viper.GetBool("test.redis_cleanup.dry_run") {
+		t.Fatal("redis cleanup: dry run is only supported by TestCleanRedis")
 	}
-	reader := csv.NewReader(file)
-	phoneToAuth := make(map[string]string)
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			// 如果到达文件末尾，结束循环
-			if err.Error() == "EOF" {
-				break
-			}
-			// 其他错误，打印错误信息
-			t.Fatalf("Failed to parse AuthsFile: %v", err)
-		}
-		// 确保每行至少有两列
-		if len(record) < 2 {
-			t.Fatalf("Failed to parse AuthsFile, expected format : {phone},{authorization}")
-		}
-		phoneToAuth[record[0]] = record[1]
+	if err := DestructiveGuard.AllowRedis(); err != nil {
+		t.Fatalf("redis cleanup: %v", err)
+	}
+	ctx := context.Background()
+	results, err := utils.CleanupRedisKeys(ctx, RedisClient, redisCleanupPatterns(voucherId), viper.GetInt("test.redis_cleanup.batch_size"), false)
+	for _, result := range results {
+		DestructiveGuard.Record(DestructiveGuard.RedisTarget(), "UNLINK "+result.Pattern, nil, result.Deleted, nil)
+		fmt.Printf("redis cleanup %s: removed %d keys\n", result.Pattern, result.Deleted)
 	}
-	assert.NotEmpty(t, phoneToAuth)
-	return phoneToAuth
-}
-func TestAddSeckillVoucher(t *testing.T) {
-	phoneToAuth := getPhonesAndAuths(t)
-	var authorization string
-	for phone := range phoneToAuth {
-		authorization = phoneToAuth[phone]
-		break
-	}
-	payload := map[string]interface{}{
-		"shopId":      1,
-		"title":       "100元代金券",
-		"subTitle":    "周一至周五均可使用",
-		"rules":       "全场通用",
-		"payValue":    8000,
-		"actualValue": 10000,
-		"type":        1,
-		"stock":       100,
-		"beginTime":   "2025-01-25T10:09:17",
-		"endTime":     "2030-12-31T12:09:04",
-	}
-	request := HttpClient.R()
-	request.Header.Set("Authorization", authorization)
-	request = request.SetBody(payload)
-	response, err := request.Post(AddSeckillVoucherUrl)
 	if err != nil {
-		return
+		DestructiveGuard.Record(DestructiveGuard.RedisTarget(), "UNLINK", nil, 0, err)
+		t.Fatalf("redis cleanup error: %v", err)
 	}
-	var result models.Result
-	err = json.Unmarshal(response.Body(), &result)
+	// 恢复redis库存
+	err = RedisClient.Set(ctx, "seckill:stock:"+voucherId, strconv.Itoa(stock), 0).Err()
+	DestructiveGuard.Record(DestructiveGuard.RedisTarget(), "SET seckill:stock:"+voucherId, []any{stock}, 1, err)
 	if err != nil {
-		t.Fatalf("Failed to unmarshal response: %v", err)
+		t.Fatalf("reset redis stock error: %v", err)
 	}
-	assert.Equal(t, result.Success, true)
 }
-func cleanRedisDatabase(ctx context.Context, voucherId string, stock int) {
-	// 删除redis购买记录
-	RedisClient.Del(ctx, "seckill:order:"+voucherId)
-	// 恢复redis库存
-	RedisClient.Set(ctx, "seckill:stock:"+voucherId, strconv.Itoa(stock), -1)
-	var keys []string
-	// 删除登录token
-	//keys = RedisClient.Keys(ctx, "login:*").Val()
-	//RedisClient.Del(ctx, keys...)
-	// 删除自定义拦截器
-	keys = RedisClient.Keys(ctx, "rate:*").Val()
-	RedisClient.Del(ctx, keys...)
-	keys = RedisClient.Keys(ctx, "{rate:*").Val()
-	RedisClient.Del(ctx, keys...)
+
+// previewRedisCleanup 列出cleanRedisDatabase会删除的key，不做任何修改
+func previewRedisCleanup(t *testing.T, voucherId string, stock int) {
+	t.Helper()
+	results, err := utils.CleanupRedisKeys(context.Background(), RedisClient, redisCleanupPatterns(voucherId), viper.GetInt("test.redis_cleanup.batch_size"), true)
+	for _, result := range results {
+		fmt.Printf("redis cleanup (dry run) %s: %d keys would be removed\n", result.Pattern, result.Matched)
+		for _, key := range result.Keys {
+			fmt.Printf("  %s\n", key)
+		}
+	}
+	if err != nil {
+		t.Fatalf("redis cleanup error: %v", err)
+	}
+	fmt.Printf("redis cleanup (dry run): would reset seckill:stock:%s to %d\n", voucherId, stock)
 }
+
 func cleanDatabase(t *testing.T, phoneToAuth map[string]string, voucherId string, stock int) {
 	phones := make([]string, 0, len(phoneToAuth))
 	for phone := range phoneToAuth {
@@ -177,7 +139,7 @@ func truncateMysqlTable(t *testing.T, db *sql.DB, dbName, tableName string) {
 
 	// 2. 动态执行 TRUNCATE（注意表名需安全处理）
 	truncateQuery := fmt.Sprintf("TRUNCATE TABLE `%s`.`%s`", dbName, tableName)
-	_, err = db.Exec(truncateQuery)
+	_, err = DestructiveGuard.Exec(context.Background(), db, truncateQuery)
 	if err != nil {
 		t.Fatalf("failed to truncate table: %v", err)
 	}
@@ -185,7 +147,7 @@ func truncateMysqlTable(t *testing.T, db *sql.DB, dbName, tableName string) {
 
 func deleteMysqlOrders(t *testing.T, voucherId string) {
 	del := fmt.Sprintf("delete from tb_voucher_order where voucher_id = ? ")
-	_, err := DBClient.Exec(del, voucherId)
+	_, err := DestructiveGuard.Exec(context.Background(), DBClient, del, voucherId)
 	if err != nil {
 		t.Fatalf("failed to exec delete voucher order: %s", err.Error())
 	}
@@ -193,36 +155,260 @@ func deleteMysqlOrders(t *testing.T, voucherId string) {
 
 func restoreMysqlVoucherStock(t *testing.T, voucherId string, stock int) {
 	update := "update tb_seckill_voucher set stock = ? where voucher_id = ?"
-	_, err := DBClient.Exec(update, stock, voucherId)
+	_, err := DestructiveGuard.Exec(context.Background(), DBClient, update, stock, voucherId)
 	if err != nil {
 		t.Fatalf("failed to exec update voucher: %s", err.Error())
 	}
 }
 
 func TestSeckillVoucher(t *testing.T) {
-	voucherId := viper.GetString("test.voucher.id")
-	stock := viper.GetInt("test.voucher.stock")
-	cleanRedisDatabase(context.Background(), voucherId, stock)
 	//err := os.Remove(AuthsFilePath)
 	//if err != nil && !os.IsNotExist(err) {
 	//	t.Fatalf("failed to remove auth file: %v", err)
 	//}
 	//TestGenerateAuths(t)
 	//panic("implement me")
+	requireDestructive(t)
+	requireRedisCleanup(t)
 	phonesAndAuths := getPhonesAndAuths(t)
+	voucher := singleVoucherConfig()
+	provisionVoucher(t, phonesAndAuths, &voucher)
+	voucherId := voucher.ID
+	stock := voucher.Stock
+	cleanRedisDatabase(t, voucherId, stock)
 	cleanDatabase(t, phonesAndAuths, voucherId, stock)
 	wg := &sync.WaitGroup{}
 	wg.Add(len(phonesAndAuths))
-	requestStats := utils.NewRequestStats()
-	purchaseSeckillVoucher(phonesAndAuths, voucherId, wg, time.Duration(viper.GetInt("test.voucher.purchase_duration_sec"))*time.Second, requestStats)
+	requestStats := utils.NewMetricsRegistry().Stats(utils.Labels{Scenario: "seckill", Endpoint: "purchase", Voucher: voucherId})
+	fairness := utils.NewFairnessRecorder()
+	duration := time.Duration(viper.GetInt("test.voucher.purchase_duration_sec")) * time.Second
+	monitor := startRunMonitor(requestStats, duration, voucher.ID)
+	think := voucherThinkTime(t, voucher)
+	retry := purchaseRetryConfig(t)
+	purchaseSeckillVoucher(phonesAndAuths, voucher, think, retry, wg, duration, requestStats, fairness, monitor.selfMonitor())
 	wg.Wait()
-	assert.GreaterOrEqual(t, min(stock, len(phonesAndAuths)), int(requestStats.PurchaseSuccessCount.Load()))
+	monitor.stop()
+	assert.GreaterOrEqual(t, min(stock, len(phonesAndAuths)), int(requestStats.Count(utils.OutcomeSuccess)))
 	fmt.Println(requestStats)
+	fmt.Println(fairness.Analyze())
+	if retry != nil {
+		fmt.Println(retry.recorder)
+	}
+	verifyOnePersonOneOrder(t, voucherId, fairness)
+}
+
+// singleVoucherConfig 读取 test.voucher 下的单券配置
+func singleVoucherConfig() models.SeckillVoucher {
+	return models.SeckillVoucher{
+		ID:              viper.GetString("test.voucher.id"),
+		Stock:           viper.GetInt("test.voucher.stock"),
+		MaxConcurrency:  viper.GetInt("test.voucher.max_concurrency"),
+		RequestsPerUser: viper.GetInt("test.voucher.requests_per_user"),
+		ThinkTime: models.ThinkTimeConfig{
+			Distribution: viper.GetString("test.voucher.think_time.distribution"),
+			Ms:           viper.GetInt("test.voucher.think_time.ms"),
+			MinMs:        viper.GetInt("test.voucher.think_time.min_ms"),
+			MaxMs:        viper.GetInt("test.voucher.think_time.max_ms"),
+			MeanMs:       viper.GetInt("test.voucher.think_time.mean_ms"),
+			StddevMs:     viper.GetInt("test.voucher.think_time.stddev_ms"),
+		},
+	}
+}
+
+// voucherThinkTime 持续抢购时的思考时间，未配置时每次请求后固定等待500ms
+func voucherThinkTime(t *testing.T, voucher models.SeckillVoucher) utils.ThinkTime {
+	t.Helper()
+	if voucher.ThinkTime == (models.ThinkTimeConfig{}) {
+		return utils.ConstantThinkTime(500 * time.Millisecond)
+	}
+	think, err := utils.NewThinkTime(voucher.ThinkTime)
+	if err != nil {
+		t.Fatalf("voucher %s: %v", voucher.ID, err)
+	}
+	return think
+}
+
+// multiVoucherConfigs 读取 test.vouchers 下的多券配置，未配置的并发参数沿用 test.voucher
+func multiVoucherConfigs(t *testing.T) []models.SeckillVoucher {
+	t.Helper()
+	var vouchers []models.SeckillVoucher
+	if err := viper.UnmarshalKey("test.vouchers", &vouchers); err != nil {
+		t.Fatalf("failed to parse test.vouchers: %v", err)
+	}
+	defaults := singleVoucherConfig()
+	for i := range vouchers {
+		if vouchers[i].MaxConcurrency == 0 {
+			vouchers[i].MaxConcurrency = defaults.MaxConcurrency
+		}
+		if vouchers[i].RequestsPerUser == 0 {
+			vouchers[i].RequestsPerUser = defaults.RequestsPerUser
+		}
+		if vouchers[i].ThinkTime == (models.ThinkTimeConfig{}) {
+			vouchers[i].ThinkTime = defaults.ThinkTime
+		}
+	}
+	return vouchers
 }
 
-func purchaseSeckillVoucherWorker(stats *utils.RequestStats, url string, request *resty.Request) {
+// selectVoucherUsers 按券配置从用户池中随机抽取参与抢购的用户，不同券之间的用户可以重叠
+func selectVoucherUsers(phonesAndAuths map[string]string, voucher models.SeckillVoucher) map[string]string {
+	count := len(phonesAndAuths)
+	if voucher.UserCount > 0 {
+		count = min(voucher.UserCount, count)
+	} else if voucher.UserWeight > 0 {
+		count = min(int(float64(count)*voucher.UserWeight), count)
+	}
+	phones := make([]string, 0, len(phonesAndAuths))
+	for phone := range phonesAndAuths {
+		phones = append(phones, phone)
+	}
+	sort.Strings(phones)
+	rand.Shuffle(len(phones), func(i, j int) { phones[i], phones[j] = phones[j], phones[i] })
+	selected := make(map[string]string, count)
+	for _, phone := range phones[:count] {
+		selected[phone] = phonesAndAuths[phone]
+	}
+	return selected
+}
+
+func TestMultiVoucherSeckill(t *testing.T) {
+	vouchers := multiVoucherConfigs(t)
+	if len(vouchers) == 0 {
+		t.Skip("test.vouchers not configured")
+	}
+	requireDestructive(t)
+	requireRedisCleanup(t)
+	phonesAndAuths := getPhonesAndAuths(t)
+	duration := time.Duration(viper.GetInt("test.voucher.purchase_duration_sec")) * time.Second
+	registry := utils.NewMetricsRegistry()
+	statsList := make([]*utils.RequestStats, len(vouchers))
+	fairnessList := make([]*utils.FairnessRecorder, len(vouchers))
+	users := make([]map[string]string, len(vouchers))
+	thinks := make([]utils.ThinkTime, len(vouchers))
+	retries := make([]*purchaseRetry, len(vouchers))
+	for i := range vouchers {
+		provisionVoucher(t, phonesAndAuths, &vouchers[i])
+		voucher := vouchers[i]
+		cleanRedisDatabase(t, voucher.ID, voucher.Stock)
+		cleanMysqlDatabase(t, phonesAndAuths, voucher.ID, voucher.Stock)
+		statsList[i] = registry.Stats(utils.Labels{Scenario: "multi voucher", Endpoint: "purchase", Voucher: voucher.ID})
+		fairnessList[i] = utils.NewFairnessRecorder()
+		users[i] = selectVoucherUsers(phonesAndAuths, voucher)
+		thinks[i] = voucherThinkTime(t, voucher)
+		retries[i] = purchaseRetryConfig(t)
+	}
+
+	// 不带券标签的视图汇总所有券
+	aggregate := registry.Stats(utils.Labels{Scenario: "multi voucher", Endpoint: "purchase"})
+	voucherIds := make([]string, len(vouchers))
+	for i, voucher := range vouchers {
+		voucherIds[i] = voucher.ID
+	}
+	monitor := startRunMonitor(aggregate, duration, voucherIds...)
+	// 所有券同时开抢
+	voucherWg := &sync.WaitGroup{}
+	for i, voucher := range vouchers {
+		voucherWg.Add(1)
+		go func() {
+			defer voucherWg.Done()
+			wg := &sync.WaitGroup{}
+			wg.Add(len(users[i]))
+			purchaseSeckillVoucher(users[i], voucher, thinks[i], retries[i], wg, duration, statsList[i], fairnessList[i], monitor.selfMonitor())
+			wg.Wait()
+		}()
+	}
+	voucherWg.Wait()
+	monitor.stop()
+
+	for i, voucher := range vouchers {
+		fmt.Printf("voucher %s (stock %d, users %d):\n", voucher.ID, voucher.Stock, len(users[i]))
+		fmt.Println(statsList[i])
+		fmt.Println(fairnessList[i].Analyze())
+		if retries[i] != nil {
+			fmt.Println(retries[i].recorder)
+		}
+		assert.GreaterOrEqual(t, min(voucher.Stock, len(users[i])), int(statsList[i].Count(utils.OutcomeSuccess)))
+		verifyOnePersonOneOrder(t, voucher.ID, fairnessList[i])
+	}
+	for _, stats := range statsList {
+		if aggregate.StartTime.IsZero() || stats.StartTime.Before(aggregate.StartTime) {
+			aggregate.StartTime = stats.StartTime
+		}
+		if stats.EndTime.After(aggregate.EndTime) {
+			aggregate.EndTime = stats.EndTime
+		}
+	}
+	fmt.Println("aggregate:")
+	fmt.Println(aggregate)
+	fmt.Println(registry.Report(aggregate.Labels, utils.LabelVoucher, utils.LabelOutcome))
+}
+
+// waitMysqlOrders 订单异步落库，等待MySQL订单数追上Redis中的下单记录，返回两边的订单数
+func waitMysqlOrders(t *testing.T, voucherId string) (int64, int64) {
+	t.Helper()
+	redisOrders, err := RedisClient.SCard(context.Background(), "seckill:order:"+voucherId).Result()
+	if err != nil {
+		t.Fatalf("failed to count redis orders: %v", err)
+	}
+	var mysqlOrders int64
+	deadline := time.Now().Add(time.Duration(viper.GetInt("test.voucher.verify_timeout_sec")) * time.Second)
+	for {
+		err := DBClient.QueryRow("select count(*) from tb_voucher_order where voucher_id = ?", voucherId).Scan(&mysqlOrders)
+		if err != nil {
+			t.Fatalf("failed to count mysql orders: %v", err)
+		}
+		if mysqlOrders >= redisOrders || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return redisOrders, mysqlOrders
+}
+
+// verifyOnePersonOneOrder 校验每个用户在Redis和MySQL中最多只有一个订单
+func verifyOnePersonOneOrder(t *testing.T, voucherId string, fairness *utils.FairnessRecorder) {
+	t.Helper()
+	for _, outcome := range fairness.Outcomes() {
+		if outcome.Successes > 1 {
+			t.Errorf("user %s got %d successful purchase responses", outcome.Phone, outcome.Successes)
+		}
+	}
+
+	redisOrders, mysqlOrders := waitMysqlOrders(t, voucherId)
+	assert.Equal(t, redisOrders, mysqlOrders, "redis and mysql order count mismatch")
+
+	query := "select user_id, count(*) from tb_voucher_order where voucher_id = ? group by user_id having count(*) > 1"
+	rows, err := DBClient.Query(query, voucherId)
+	if err != nil {
+		t.Fatalf("failed to query duplicate orders: %v", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	for rows.Next() {
+		var userId, count int64
+		if err := rows.Scan(&userId, &count); err != nil {
+			t.Fatalf("failed to scan row: %v", err)
+		}
+		t.Errorf("user %d has %d orders for voucher %s", userId, count, voucherId)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("query duplicate orders error: %v", err)
+	}
+}
+
+func purchaseSeckillVoucherWorker(stats *utils.RequestStats, url string, request *resty.Request) utils.Outcome {
+	outcome, _ := purchaseSeckillVoucherResultWorker(stats, url, request)
+	return outcome
+}
+
+// purchaseSeckillVoucherResultWorker 发送一次购买请求，同时返回解析出的响应，请求失败时响应为空
+func purchaseSeckillVoucherResultWorker(stats *utils.RequestStats, url string, request *resty.Request) (utils.Outcome, models.Result) {
+	var result models.Result
+	done := stats.Begin()
 	start := time.Now()
 	response, err := request.Post(url)
+	done()
 	defer func(body io.ReadCloser) {
 		if body == nil {
 			return
@@ -234,59 +420,137 @@ func purchaseSeckillVoucherWorker(stats *utils.RequestStats, url string, request
 	elapsed := time.Since(start)
 	nanosecond := uint64(elapsed.Nanoseconds())
 	if err != nil || response == nil {
-		stats.Record(utils.ResponseFail, nanosecond)
+		stats.RecordWithReason(utils.OutcomeError, nanosecond, utils.ErrorReason(err))
 		//fmt.Println(err.Error())
-		return
+		return utils.OutcomeError, result
 	}
-	var result models.Result
 	err = json.Unmarshal(response.Body(), &result)
 	if err != nil {
-		stats.Record(utils.ResponseFail, nanosecond)
-		return
+		stats.RecordWithReason(utils.OutcomeError, nanosecond, "http "+strconv.Itoa(response.StatusCode()))
+		return utils.OutcomeError, result
 	}
 	if result.Success {
 		s := result.Data.String()
 		_, err := strconv.ParseInt(s, 10, 64)
 		if err == nil {
-			stats.Record(utils.PurchaseSuccess, nanosecond)
-			return
+			stats.Record(utils.OutcomeSuccess, nanosecond)
+			return utils.OutcomeSuccess, result
 		}
 	}
-	stats.Record(utils.PurchaseFail, nanosecond)
+	stats.RecordWithReason(utils.OutcomeRejected, nanosecond, result.ErrorMsg)
+	return utils.OutcomeRejected, result
 }
 
-func purchaseSeckillVoucherTimeoutContextWorker(ctx context.Context, stats *utils.RequestStats, url string, request *resty.Request) {
-	for {
-		select {
-		case <-ctx.Done():
+// purchaseSeckillVoucherRecordedWorker 在发送前记录时间戳，并把结果记入公平性统计
+func purchaseSeckillVoucherRecordedWorker(stats *utils.RequestStats, fairness *utils.FairnessRecorder, phone string, url string, request *resty.Request) utils.Outcome {
+	sendTime := time.Now()
+	outcome := purchaseSeckillVoucherWorker(stats, url, request)
+	if fairness != nil {
+		fairness.Record(phone, sendTime, outcome)
+	}
+	return outcome
+}
+
+// purchaseSeckillVoucherTimeoutContextWorker 在ctx结束前反复购买，每次请求后等待一次思考时间
+func purchaseSeckillVoucherTimeoutContextWorker(ctx context.Context, stats *utils.RequestStats, fairness *utils.FairnessRecorder, phone string, url string, request *resty.Request, think utils.ThinkTime) {
+	for ctx.Err() == nil {
+		purchaseSeckillVoucherRecordedWorker(stats, fairness, phone, url, request)
+		if !utils.SleepContext(ctx, think.Next()) {
 			return
-		default:
-			purchaseSeckillVoucherWorker(stats, url, request)
-			time.Sleep(time.Millisecond * 500)
 		}
 	}
 }
 
-func purchaseSeckillVoucher(phonesAndAuths map[string]string, voucherId string, wg *sync.WaitGroup, duration time.Duration, stats *utils.RequestStats) {
+// purchaseRetry 重试模式的策略和每个用户的重试结果
+type purchaseRetry struct {
+	policy   utils.RetryPolicy
+	recorder *utils.RetryRecorder
+}
+
+// purchaseRetryConfig 读取 test.voucher.retry，未开启时返回nil
+func purchaseRetryConfig(t *testing.T) *purchaseRetry {
+	t.Helper()
+	if !viper.GetBool("test.voucher.retry.enabled") {
+		return nil
+	}
+	ms := func(key string) time.Duration { return time.Duration(viper.GetInt(key)) * time.Millisecond }
+	policy := utils.RetryPolicy{
+		MaxAttempts: viper.GetInt("test.voucher.retry.max_attempts"),
+		Backoff: utils.Backoff{
+			Initial:    ms("test.voucher.retry.backoff.initial_ms"),
+			Max:        ms("test.voucher.retry.backoff.max_ms"),
+			Multiplier: viper.GetFloat64("test.voucher.retry.backoff.multiplier"),
+			Jitter:     viper.GetFloat64("test.voucher.retry.backoff.jitter"),
+		},
+		SoldOutMessages:   viper.GetStringSlice("test.voucher.retry.sold_out_messages"),
+		DuplicateMessages: viper.GetStringSlice("test.voucher.retry.duplicate_messages"),
+	}
+	// 不限次数时只能靠抢购时间结束
+	if policy.MaxAttempts <= 0 && viper.GetInt("test.voucher.purchase_duration_sec") <= 0 {
+		t.Fatal("test.voucher.retry needs max_attempts or purchase_duration_sec")
+	}
+	return &purchaseRetry{policy: policy, recorder: utils.NewRetryRecorder()}
+}
+
+// purchaseSeckillVoucherRetryWorker 失败后按退避重试，直到抢到、售罄、重复下单、用完次数或ctx结束，每次请求前通过acquire占用一个并发槽位
+func purchaseSeckillVoucherRetryWorker(ctx context.Context, stats *utils.RequestStats, fairness *utils.FairnessRecorder, phone string, url string, request *resty.Request, retry *purchaseRetry,
+	acquire func(ctx context.Context) (func(), bool)) {
+	attempts, stop := utils.RetryUntilDone(ctx, retry.policy, acquire, func() (utils.Outcome, string) {
+		sendTime := time.Now()
+		outcome, result := purchaseSeckillVoucherResultWorker(stats, url, request)
+		if fairness != nil {
+			fairness.Record(phone, sendTime, outcome)
+		}
+		return outcome, result.ErrorMsg
+	})
+	retry.recorder.Record(attempts, stop)
+}
+
+func purchaseSeckillVoucher(phonesAndAuths map[string]string, voucher models.SeckillVoucher, think utils.ThinkTime, retry *purchaseRetry, wg *sync.WaitGroup, duration time.Duration, stats *utils.RequestStats, fairness *utils.FairnessRecorder, monitor *utils.SelfMonitor) {
 	ctx, cancel := context.WithTimeout(context.Background(), duration)
 	defer cancel()
-	maxConcurrency := viper.GetInt("test.voucher.max_concurrency")
-	sem := make(chan struct{}, maxConcurrency)
+	voucherId := voucher.ID
+	sem := make(chan struct{}, max(voucher.MaxConcurrency, 1))
+	// 每个用户同时发出的购买请求数，大于1时用于压测一人一单
+	requestsPerUser := max(voucher.RequestsPerUser, 1)
 	stats.StartTime = time.Now()
+	if retry != nil {
+		purchaseSeckillVoucherWithRetry(ctx, phonesAndAuths, voucherId, retry, sem, wg, duration, stats, fairness, monitor)
+		return
+	}
 	for phone := range phonesAndAuths {
+		waitStart := time.Now()
 		sem <- struct{}{} // 阻塞知道有可用槽位
+		if monitor != nil {
+			monitor.RecordSemaphoreWait(time.Since(waitStart))
+		}
 		go func() {
 			defer wg.Done()
 			defer func() { <-sem }()
 			url := PurchaseSeckillVoucherUrlPrefix + "/" + voucherId
 			auth := phonesAndAuths[phone]
-			request := HttpClient.R()
-			request.Header.Set("Authorization", auth)
-			if duration == 0 {
-				purchaseSeckillVoucherWorker(stats, url, request)
-			} else {
-				go purchaseSeckillVoucherTimeoutContextWorker(ctx, stats, url, request)
+			userWg := &sync.WaitGroup{}
+			start := make(chan struct{})
+			for range requestsPerUser {
+				// resty.Request 不是并发安全的，每个并发请求单独创建
+				request := HttpClient.R()
+				request.Header.Set("Authorization", auth)
+				if duration == 0 {
+					userWg.Add(1)
+					go func() {
+						defer userWg.Done()
+						<-start // 等待同一用户的所有请求就绪后同时发出
+						purchaseSeckillVoucherRecordedWorker(stats, fairness, phone, url, request)
+					}()
+				} else {
+					go func() {
+						<-start
+						purchaseSeckillVoucherTimeoutContextWorker(ctx, stats, fairness, phone, url, request, think)
+					}()
+				}
 			}
+			close(start)
+			userWg.Wait()
 		}()
 	}
 	extra := time.Second
@@ -294,14 +558,64 @@ func purchaseSeckillVoucher(phonesAndAuths map[string]string, voucherId string,
 	stats.EndTime = time.Now().Add(-extra)
 }
 
+// purchaseSeckillVoucherWithRetry 重试模式：所有用户同时开始各自的重试循环，每次请求占用一个max_concurrency槽位，
+// 退避等待时释放，避免先拿到槽位的用户一直占用到抢购结束。purchase_duration_sec为0时只受重试次数限制
+func purchaseSeckillVoucherWithRetry(ctx context.Context, phonesAndAuths map[string]string, voucherId string, retry *purchaseRetry, sem chan struct{},
+	wg *sync.WaitGroup, duration time.Duration, stats *utils.RequestStats, fairness *utils.FairnessRecorder, monitor *utils.SelfMonitor) {
+	retryCtx := context.Background()
+	if duration > 0 {
+		retryCtx = ctx
+	}
+	acquire := func(ctx context.Context) (func(), bool) {
+		waitStart := time.Now()
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, false
+		}
+		if monitor != nil {
+			monitor.RecordSemaphoreWait(time.Since(waitStart))
+		}
+		return func() { <-sem }, true
+	}
+	url := PurchaseSeckillVoucherUrlPrefix + "/" + voucherId
+	for phone, auth := range phonesAndAuths {
+		go func() {
+			defer wg.Done()
+			request := HttpClient.R()
+			request.Header.Set("Authorization", auth)
+			purchaseSeckillVoucherRetryWorker(retryCtx, stats, fairness, phone, url, request, retry, acquire)
+		}()
+	}
+	// 结束时间以最后一个用户停止为准
+	wg.Wait()
+	stats.EndTime = time.Now()
+}
+
+// TestRestoreMysqlStock 把 test.voucher 的MySQL库存恢复为配置的库存
 func TestRestoreMysqlStock(t *testing.T) {
-	restoreMysqlVoucherStock(t, "5", 200)
+	requireDestructive(t)
+	voucher := singleVoucherConfig()
+	restoreMysqlVoucherStock(t, voucher.ID, voucher.Stock)
 }
 
 func TestDeleteOrders(t *testing.T) {
-	deleteMysqlOrders(t, "5")
+	requireDestructive(t)
+	deleteMysqlOrders(t, singleVoucherConfig().ID)
 }
 
 func TestTruncateMessages(t *testing.T) {
+	requireDestructive(t)
 	truncateMessages(t)
 }
+
+// TestCleanRedis 单独清理 test.voucher 的Redis数据，开启 test.redis_cleanup.dry_run 时只列出会删除哪些key
+func TestCleanRedis(t *testing.T) {
+	requireDestructive(t)
+	voucher := singleVoucherConfig()
+	if viper.GetBool("test.redis_cleanup.dry_run") {
+		previewRedisCleanup(t, voucher.ID, voucher.Stock)
+		return
+	}
+	cleanRedisDatabase(t, voucher.ID, voucher.Stock)
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+	"hmdp-go-test/models"
+	"hmdp-go-test/utils"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordApiCall 执行一次接口调用并按统一规则记入统计：请求失败、非200和无法解析的响应记为error，
+// success为false记为rejected
+func recordApiCall(stats *utils.RequestStats, call func() (*resty.Response, error)) (models.Result, bool) {
+	var result models.Result
+	done := stats.Begin()
+	start := time.Now()
+	response, err := call()
+	done()
+	nanosecond := uint64(time.Since(start).Nanoseconds())
+	if err != nil || response == nil {
+		stats.RecordWithReason(utils.OutcomeError, nanosecond, utils.ErrorReason(err))
+		return result, false
+	}
+	if response.StatusCode() != 200 {
+		stats.RecordWithReason(utils.OutcomeError, nanosecond, "http "+strconv.Itoa(response.StatusCode()))
+		return result, false
+	}
+	if err := json.Unmarshal(response.Body(), &result); err != nil {
+		stats.RecordWithReason(utils.OutcomeError, nanosecond, "invalid response body")
+		return result, false
+	}
+	if !result.Success {
+		stats.RecordWithReason(utils.OutcomeRejected, nanosecond, result.ErrorMsg)
+		return result, false
+	}
+	stats.Record(utils.OutcomeSuccess, nanosecond)
+	return result, true
+}
+
+func login(phone int64, code string) (*resty.Response, error) {
+	payload := map[string]interface{}{
+		"phone": strconv.FormatInt(phone, 10),
+		"code":  code,
+	}
+	return HttpClient.R().SetBody(payload).Post(LoginUrl)
+}
+
+// runLoginStorm 所有用户同时开始发送验证码，withLogin为true时收到验证码后立即登录
+func runLoginStorm(t *testing.T, withLogin bool) *utils.MetricsRegistry {
+	t.Helper()
+	basePhone := viper.GetInt64("test.user.base_phone")
+	userCount := viper.GetInt("test.login_storm.user_count")
+	if userCount == 0 {
+		userCount = viper.GetInt("test.user.user_count")
+	}
+	sem := make(chan struct{}, max(viper.GetInt("test.login_storm.max_concurrency"), 1))
+	registry := utils.NewMetricsRegistry()
+	sendCodeStats := registry.Stats(utils.Labels{Scenario: "login storm", Endpoint: "send code"})
+	queryCodeStats := registry.Stats(utils.Labels{Scenario: "login storm", Endpoint: "query code"})
+	loginStats := registry.Stats(utils.Labels{Scenario: "login storm", Endpoint: "login"})
+
+	wg := &sync.WaitGroup{}
+	wg.Add(userCount)
+	start := make(chan struct{})
+	for i := 0; i < userCount; i++ {
+		go func(phone int64) {
+			defer wg.Done()
+			<-start
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			_, ok := recordApiCall(sendCodeStats, func() (*resty.Response, error) {
+				return sendCode(phone)
+			})
+			if !ok || !withLogin {
+				return
+			}
+			queryStart := time.Now()
+			err, code := queryCode(phone)
+			queryNano := uint64(time.Since(queryStart).Nanoseconds())
+			if err != nil {
+				queryCodeStats.RecordWithReason(utils.OutcomeError, queryNano, "code not found in redis")
+				return
+			}
+			queryCodeStats.Record(utils.OutcomeSuccess, queryNano)
+			recordApiCall(loginStats, func() (*resty.Response, error) {
+				return login(phone, code)
+			})
+		}(basePhone + int64(i))
+	}
+	monitor := startRunMonitor(registry.Stats(utils.Labels{Scenario: "login storm"}), 0)
+	now := time.Now()
+	sendCodeStats.StartTime, loginStats.StartTime = now, now
+	close(start)
+	wg.Wait()
+	monitor.stop()
+	now = time.Now()
+	sendCodeStats.EndTime, loginStats.EndTime = now, now
+	fmt.Println(sendCodeStats)
+	if withLogin {
+		fmt.Println(loginStats)
+	}
+	return registry
+}
+
+func TestSendCodeStorm(t *testing.T) {
+	runLoginStorm(t, false)
+}
+
+// TestLoginStorm 模拟活动开始时大量用户同时登录
+func TestLoginStorm(t *testing.T) {
+	registry := runLoginStorm(t, true)
+	fmt.Println(registry.Report(utils.Labels{Scenario: "login storm"}, utils.LabelEndpoint, utils.LabelOutcome))
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-resty/resty/v2"
@@ -14,15 +15,25 @@ import (
 )
 
 var DBClient *sql.DB
-var RedisClient *redis.Client
+var RedisClient redis.UniversalClient
 var HttpClient *resty.Client
 var SendCodeUrlPrefix string
 var LoginUrl string
 var AddSeckillVoucherUrl string
 var PurchaseSeckillVoucherUrlPrefix string
+var UserMeUrl string
+var ShopUrlPrefix string
+var VoucherListUrlPrefix string
 var AuthsFilePath string
+var SnapshotFilePath string
+var DestructiveGuard *utils.Guard
+
+// 清理、删除等破坏性操作需要显式确认：go test ./tests -run TestDeleteOrders -args --yes
+// 只有tests包定义了--yes，对 go test ./... 传入会让utils和models的测试因未知参数失败
+var confirmDestructive = flag.Bool("yes", false, "confirm destructive operations such as cleanup and restore")
 
 func TestMain(m *testing.M) {
+	flag.Parse()
 	// 1. 初始化配置
 	setupConfig()
 
@@ -31,6 +42,7 @@ func TestMain(m *testing.M) {
 	HttpClient = setupHttpResource()
 	setupUrls()
 	setupFilePaths()
+	setupGuard()
 	defer teardownResources(DBClient, RedisClient)
 
 	// 3. 运行测试套件
@@ -45,6 +57,9 @@ func setupUrls() {
 	LoginUrl = viper.GetString("api.base_url") + viper.GetString("api.prefix.login")
 	AddSeckillVoucherUrl = viper.GetString("api.base_url") + viper.GetString("api.prefix.voucher")
 	PurchaseSeckillVoucherUrlPrefix = viper.GetString("api.base_url") + viper.GetString("api.prefix.purchase")
+	UserMeUrl = viper.GetString("api.base_url") + viper.GetString("api.prefix.me")
+	ShopUrlPrefix = viper.GetString("api.base_url") + viper.GetString("api.prefix.shop")
+	VoucherListUrlPrefix = viper.GetString("api.base_url") + viper.GetString("api.prefix.voucher_list")
 }
 
 func setupFilePaths() {
@@ -53,6 +68,7 @@ func setupFilePaths() {
 		panic("Failed to get working directory: " + err.Error())
 	}
 	AuthsFilePath = filepath.Join(dir, viper.GetString("test.user.auth_file_name"))
+	SnapshotFilePath = filepath.Join(dir, viper.GetString("test.snapshot.file_name"))
 }
 
 func setupConfig() {
@@ -62,21 +78,52 @@ func setupConfig() {
 	}
 }
 
-func setupDBResources() (*sql.DB, *redis.Client) {
+func setupDBResources() (*sql.DB, redis.UniversalClient) {
 	// 初始化数据库连接等
 	mysqlClient, err := utils.InitMySQL()
 	if err != nil {
 		log.Fatal(err)
 		return nil, nil
 	}
-	return mysqlClient, utils.InitRedis()
+	redisClient, err := utils.InitRedis()
+	if err != nil {
+		log.Fatal(err)
+		return nil, nil
+	}
+	return mysqlClient, redisClient
+}
+
+// setupGuard 按 env 和 guard 配置创建破坏性操作的守卫
+func setupGuard() {
+	DestructiveGuard = utils.NewGuard(viper.GetString("env"), viper.GetStringSlice("guard.allowed_hosts"), *confirmDestructive)
+	DestructiveGuard.MySQLHost = viper.GetString("database.mysql.host")
+	DestructiveGuard.RedisHosts = viper.GetStringSlice("database.redis.addresses")
+	if len(DestructiveGuard.RedisHosts) == 0 {
+		DestructiveGuard.RedisHosts = []string{viper.GetString("database.redis.address")}
+	}
+	if path := viper.GetString("guard.audit_log"); path != "" {
+		if err := DestructiveGuard.OpenAuditLog(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// requireDestructive 在执行任何操作前检查能否清理MySQL和Redis，避免做了一半才被拒绝
+func requireDestructive(t *testing.T) {
+	t.Helper()
+	if err := DestructiveGuard.AllowMySQL(); err != nil {
+		t.Fatalf("mysql: %v", err)
+	}
+	if err := DestructiveGuard.AllowRedis(); err != nil {
+		t.Fatalf("redis: %v", err)
+	}
 }
 
 func setupHttpResource() *resty.Client {
 	return utils.InitHttpClient()
 }
 
-func teardownResources(db *sql.DB, redis *redis.Client) {
+func teardownResources(db *sql.DB, redis redis.UniversalClient) {
 	// 关闭连接等清理工作
 	err := db.Close()
 	if err != nil {
@@ -88,6 +135,7 @@ func teardownResources(db *sql.DB, redis *redis.Client) {
 		log.Fatal(err)
 		return
 	}
+	_ = DestructiveGuard.Close()
 }
 
 func TestWorkPath(t *testing.T) {
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"hmdp-go-test/utils"
+	"io"
+	"os"
+	"time"
+)
+
+// runMonitor 一次运行中的采样器和指标服务，客户端和服务端的采样写入同一条时间线
+type runMonitor struct {
+	self    *utils.SelfMonitor
+	series  *utils.TimeSeries
+	server  *utils.MetricsServer
+	stops   []func()
+	sampled bool
+}
+
+// startRunMonitor 按 test.monitor 配置启动采样，配置了 test.metrics.listen_addr 时同时暴露 /metrics，
+// 开启 test.dashboard 时输出实时面板。duration为计划运行时长，voucherIds为需要采样库存的券
+func startRunMonitor(stats *utils.RequestStats, duration time.Duration, voucherIds ...string) *runMonitor {
+	m := &runMonitor{series: utils.NewTimeSeries()}
+	interval := time.Duration(max(viper.GetInt("test.monitor.interval_ms"), 100)) * time.Millisecond
+	dashboard := viper.GetBool("test.dashboard.enabled") && stats != nil
+	if len(voucherIds) > 0 && (dashboard || viper.GetBool("test.monitor.enabled")) {
+		m.stops = append(m.stops, m.series.StartSampler(utils.StockSource, interval, utils.StockSampler(RedisClient, voucherIds...)))
+	}
+	if viper.GetBool("test.monitor.enabled") {
+		m.self = utils.NewSelfMonitor()
+		m.sampled = true
+		m.stops = append(m.stops, m.series.StartSampler(utils.GeneratorSource, interval, m.self.Sample))
+		if stats != nil {
+			m.stops = append(m.stops, m.series.StartSampler(utils.RequestsSource, interval, stats.Sampler()))
+		}
+		if viper.GetBool("test.monitor.backend") {
+			m.stops = append(m.stops, m.series.StartSampler(utils.RedisSource, interval, utils.RedisSampler(RedisClient)))
+			m.stops = append(m.stops, m.series.StartSampler(utils.MySQLSource, interval, utils.MySQLSampler(DBClient)))
+		}
+	}
+	if addr := viper.GetString("test.metrics.listen_addr"); addr != "" && stats != nil {
+		server, err := utils.StartMetricsServer(addr, &utils.PrometheusExporter{Registry: stats.Registry, Series: m.series})
+		if err != nil {
+			fmt.Printf("failed to start metrics server: %v\n", err)
+		} else {
+			fmt.Printf("metrics available at http://%s/metrics\n", server.Addr())
+			m.server = server
+		}
+	}
+	if dashboard {
+		m.stops = append(m.stops, startDashboard(stats, m.series, duration))
+	}
+	return m
+}
+
+// startDashboard 按 test.dashboard.mode 选择整屏刷新或逐行输出
+func startDashboard(stats *utils.RequestStats, series *utils.TimeSeries, duration time.Duration) (stop func()) {
+	var out io.Writer = os.Stdout
+	full := false
+	switch viper.GetString("test.dashboard.mode") {
+	case "full":
+		full = true
+		if tty, ok := utils.TerminalOutput(); ok {
+			out = tty
+		}
+	case "plain":
+	default:
+		// 报告通过fmt.Printf写在标准输出上，只有标准输出本身就是终端时才整屏刷新，
+		// 否则写到 /dev/tty 的清屏会和报告交错，改为逐行输出
+		if tty, ok := utils.TerminalOutput(); ok {
+			if tty == os.Stdout {
+				full = true
+			} else {
+				_ = tty.Close()
+			}
+		}
+	}
+	dashboard := utils.NewDashboard(stats, series, duration, out, full)
+	if window := viper.GetInt("test.dashboard.window_sec"); window > 0 {
+		dashboard.Window = time.Duration(window) * time.Second
+	}
+	stopDashboard := dashboard.Start(time.Duration(max(viper.GetInt("test.dashboard.interval_ms"), 100)) * time.Millisecond)
+	return func() {
+		stopDashboard()
+		if closer, ok := out.(io.Closer); ok && out != os.Stdout {
+			_ = closer.Close()
+		}
+	}
+}
+
+// selfMonitor 返回客户端自身的监控，未开启采样时返回nil
+func (m *runMonitor) selfMonitor() *utils.SelfMonitor {
+	return m.self
+}
+
+// stop 停止所有采样和指标服务并输出报告，按配置把时间线导出到文件
+func (m *runMonitor) stop() {
+	for _, stop := range m.stops {
+		stop()
+	}
+	if m.server != nil {
+		// 留出最后一次抓取的时间
+		time.Sleep(time.Duration(viper.GetInt("test.metrics.linger_sec")) * time.Second)
+		_ = m.server.Close()
+	}
+	if !m.sampled {
+		return
+	}
+	fmt.Println(m.self.Report(m.series))
+	for _, source := range []string{utils.RequestsSource, utils.RedisSource, utils.MySQLSource} {
+		if len(m.series.Samples(source)) > 0 {
+			fmt.Println(m.series.FormatSummary(source))
+		}
+	}
+	if path := viper.GetString("test.monitor.output_file"); path != "" {
+		if err := writeTimeSeries(path, m.series); err != nil {
+			fmt.Printf("failed to write time series: %v\n", err)
+		}
+	}
+}
+
+func writeTimeSeries(path string, series *utils.TimeSeries) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := series.WriteCSV(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,411 @@
+package tests
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+	"hmdp-go-test/models"
+	"hmdp-go-test/utils"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 场景文件，默认为 test.scenario.file：go test ./tests -run TestScenario -args --scenario=../scenarios/flash_sale.yaml --yes
+var scenarioFile = flag.String("scenario", "", "scenario file to run with TestScenario")
+
+// scenarioRun 一次场景运行中请求需要用到的数据
+type scenarioRun struct {
+	voucherId string
+	shopId    int64
+	fairness  *utils.FairnessRecorder
+	// 按 test.voucher.retry 的售罄和重复下单提示判断用户是否已经不需要再抢购
+	purchasePolicy utils.RetryPolicy
+	// 已经抢到、重复下单或遇到售罄的手机号，跨阶段保留
+	purchaseStopped sync.Map
+	// 会话的统计，与请求的registry分开，避免实时面板和/metrics把会话再算作一次请求
+	sessions *utils.MetricsRegistry
+}
+
+// purchaseDone 用户是否已经抢到券或不可能再抢到
+func (r *scenarioRun) purchaseDone(phone string) bool {
+	_, ok := r.purchaseStopped.Load(phone)
+	return ok
+}
+
+// scenarioSession 一个虚拟用户的账号，登录后更新auth
+type scenarioSession struct {
+	phone string
+	auth  string
+}
+
+// scenarioEndpoint 场景中可以使用的一类请求
+type scenarioEndpoint struct {
+	// 请求与券有关时在指标中带上券标签
+	voucher bool
+	// 返回请求是否成功，会话中until_success的步骤据此决定是否继续
+	send func(run *scenarioRun, stats *utils.RequestStats, session *scenarioSession) bool
+}
+
+var scenarioEndpoints = map[string]scenarioEndpoint{
+	"purchase": {
+		voucher: true,
+		send: func(run *scenarioRun, stats *utils.RequestStats, session *scenarioSession) bool {
+			request := HttpClient.R()
+			request.Header.Set("Authorization", session.auth)
+			sendTime := time.Now()
+			outcome, result := purchaseSeckillVoucherResultWorker(stats, PurchaseSeckillVoucherUrlPrefix+"/"+run.voucherId, request)
+			run.fairness.Record(session.phone, sendTime, outcome)
+			if stop := run.purchasePolicy.Classify(outcome, result.ErrorMsg); stop != "" {
+				run.purchaseStopped.Store(session.phone, stop)
+			}
+			return outcome == utils.OutcomeSuccess
+		},
+	},
+	// 发送验证码、从Redis读取验证码并登录，整个过程记为一次请求
+	"login": {
+		send: func(run *scenarioRun, stats *utils.RequestStats, session *scenarioSession) bool {
+			result, ok := recordApiCall(stats, func() (*resty.Response, error) {
+				phone, err := strconv.ParseInt(session.phone, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				err, code := sendAndQueryCode(phone)
+				if err != nil {
+					return nil, err
+				}
+				return login(phone, code)
+			})
+			if ok {
+				session.auth = result.Data.String()
+			}
+			return ok
+		},
+	},
+	"me": {
+		send: func(run *scenarioRun, stats *utils.RequestStats, session *scenarioSession) bool {
+			_, ok := recordApiCall(stats, func() (*resty.Response, error) {
+				return HttpClient.R().SetHeader("Authorization", session.auth).Get(UserMeUrl)
+			})
+			return ok
+		},
+	},
+	// 浏览请求：店铺详情和店铺的优惠券列表
+	"shop": {
+		send: func(run *scenarioRun, stats *utils.RequestStats, session *scenarioSession) bool {
+			_, ok := recordApiCall(stats, func() (*resty.Response, error) {
+				return HttpClient.R().SetHeader("Authorization", session.auth).Get(ShopUrlPrefix + "/" + strconv.FormatInt(run.shopId, 10))
+			})
+			return ok
+		},
+	},
+	"voucher_list": {
+		send: func(run *scenarioRun, stats *utils.RequestStats, session *scenarioSession) bool {
+			_, ok := recordApiCall(stats, func() (*resty.Response, error) {
+				return HttpClient.R().SetHeader("Authorization", session.auth).Get(VoucherListUrlPrefix + "/" + strconv.FormatInt(run.shopId, 10))
+			})
+			return ok
+		},
+	},
+}
+
+func scenarioEndpointNames() map[string]bool {
+	names := make(map[string]bool, len(scenarioEndpoints))
+	for name := range scenarioEndpoints {
+		names[name] = true
+	}
+	return names
+}
+
+// setupScenario 准备用户和券，按场景配置注册清理
+func setupScenario(t *testing.T, scenario *models.Scenario) (*scenarioRun, []string, map[string]string) {
+	t.Helper()
+	setup := scenario.Setup
+	if setup.RefreshTokens {
+		refreshAuths(t)
+	}
+	phonesAndAuths := selectVoucherUsers(getPhonesAndAuths(t), models.SeckillVoucher{UserCount: setup.Users})
+	phones := make([]string, 0, len(phonesAndAuths))
+	for phone := range phonesAndAuths {
+		phones = append(phones, phone)
+	}
+	sort.Strings(phones)
+
+	template := voucherTemplate(t)
+	if setup.Voucher.ShopID > 0 {
+		template.ShopID = setup.Voucher.ShopID
+	}
+	run := &scenarioRun{voucherId: setup.Voucher.ID, shopId: template.ShopID, fairness: utils.NewFairnessRecorder(), sessions: utils.NewMetricsRegistry(),
+		purchasePolicy: utils.RetryPolicy{
+			SoldOutMessages:   viper.GetStringSlice("test.voucher.retry.sold_out_messages"),
+			DuplicateMessages: viper.GetStringSlice("test.voucher.retry.duplicate_messages"),
+		}}
+	if run.voucherId == "" {
+		payload := voucherPayload(template, setup.Voucher.Stock, time.Now())
+		voucherId, err := createSeckillVoucher(phonesAndAuths[phones[0]], payload)
+		if err != nil {
+			t.Fatalf("failed to create voucher: %v", err)
+		}
+		fmt.Printf("created voucher %s (stock %d)\n", voucherId, setup.Voucher.Stock)
+		run.voucherId = voucherId
+		if scenario.Teardown.DeleteVoucher {
+			t.Cleanup(func() {
+				deleteSeckillVoucher(t, voucherId)
+			})
+		}
+	}
+	// t.Cleanup 后注册先执行，恢复库存在删除券之前
+	if scenario.Teardown.ResetStock {
+		t.Cleanup(func() {
+			cleanRedisDatabase(t, run.voucherId, setup.Voucher.Stock)
+			cleanMysqlDatabase(t, nil, run.voucherId, setup.Voucher.Stock)
+		})
+	}
+	if setup.ResetStock {
+		cleanRedisDatabase(t, run.voucherId, setup.Voucher.Stock)
+		cleanMysqlDatabase(t, nil, run.voucherId, setup.Voucher.Stock)
+	}
+	return run, phones, phonesAndAuths
+}
+
+// phaseThinkTime 阶段配置的思考时间，未配置时返回nil
+func phaseThinkTime(t *testing.T, phase models.ScenarioPhase) utils.ThinkTime {
+	t.Helper()
+	if phase.ThinkTime == nil {
+		return nil
+	}
+	think, err := utils.NewThinkTime(*phase.ThinkTime)
+	if err != nil {
+		t.Fatalf("phase %s: %v", phase.Name, err)
+	}
+	return think
+}
+
+// phaseUsers 阶段的虚拟用户数，0表示setup选出的所有用户
+func phaseUsers(phase models.ScenarioPhase, phones []string) int {
+	if phase.Users > 0 {
+		return phase.Users
+	}
+	return len(phones)
+}
+
+// endpointStats 阶段中某类请求的统计
+func endpointStats(scenario *models.Scenario, phase models.ScenarioPhase, run *scenarioRun, registry *utils.MetricsRegistry, endpoint string) *utils.RequestStats {
+	labels := utils.Labels{Scenario: utils.ScenarioPhaseLabel(scenario.Name, phase.Name), Endpoint: endpoint}
+	if scenarioEndpoints[endpoint].voucher {
+		labels.Voucher = run.voucherId
+	}
+	return registry.Stats(labels)
+}
+
+// runScenarioPhase 按阶段的速率、并发和请求组合施压，配置了思考时间时按虚拟用户施压，配置了会话脚本时按会话施压
+func runScenarioPhase(t *testing.T, scenario *models.Scenario, phase models.ScenarioPhase, run *scenarioRun, registry *utils.MetricsRegistry,
+	phones []string, phonesAndAuths map[string]string, monitor *utils.SelfMonitor) {
+	t.Helper()
+	if phase.Session != nil {
+		runScenarioSessions(t, scenario, phase, run, registry, phones, phonesAndAuths)
+		return
+	}
+	weights := make([]float64, len(phase.Mix))
+	stats := make([]*utils.RequestStats, len(phase.Mix))
+	for i, request := range phase.Mix {
+		weights[i] = request.Weight
+		stats[i] = endpointStats(scenario, phase, run, registry, request.Endpoint)
+	}
+	picker, err := utils.NewWeightedPicker(weights)
+	if err != nil {
+		t.Fatalf("phase %s: %v", phase.Name, err)
+	}
+	duration := time.Duration(phase.DurationSec) * time.Second
+	send := func(phone string, i int) {
+		scenarioEndpoints[phase.Mix[i].Endpoint].send(run, stats[i], &scenarioSession{phone: phone, auth: phonesAndAuths[phone]})
+	}
+	start := time.Now()
+	var fired int64
+	if think := phaseThinkTime(t, phase); think != nil {
+		// 每个虚拟用户固定使用一个账号，用户数多于账号数时账号会被复用。
+		// 虚拟用户模拟真实用户，抢到、重复下单或售罄后不再抢购，选中purchase时跳过这一次，只等待思考时间
+		var skipped atomic.Int64
+		fired = utils.RunVirtualUsers(context.Background(), duration, phaseUsers(phase, phones), think, func(ctx context.Context, user int) {
+			phone, i := phones[user%len(phones)], picker.Pick()
+			if phase.Mix[i].Endpoint == "purchase" && run.purchaseDone(phone) {
+				skipped.Add(1)
+				return
+			}
+			send(phone, i)
+		})
+		fired -= skipped.Load()
+	} else {
+		load := utils.LoadPhase{Name: phase.Name, Duration: duration, Rate: phase.Rate, Concurrency: phase.Concurrency}
+		fired = utils.RunPhase(context.Background(), load, monitor, func(ctx context.Context) {
+			send(phones[rand.IntN(len(phones))], picker.Pick())
+		})
+	}
+	end := time.Now()
+	fmt.Printf("phase %s: %d requests in %v\n", phase.Name, fired, end.Sub(start).Round(time.Millisecond))
+	for _, s := range stats {
+		s.StartTime, s.EndTime = start, end
+		fmt.Println(s)
+	}
+}
+
+// runScenarioSessions 每个虚拟用户按会话脚本执行一次会话，步骤之间等待思考时间。
+// 会话记入 session 接口的统计：只有purchase成功的会话记为成功，记录从会话开始到最后一次purchase成功的时间；
+// 没有抢到或放弃的会话记为rejected，记录会话时长和原因
+func runScenarioSessions(t *testing.T, scenario *models.Scenario, phase models.ScenarioPhase, run *scenarioRun, registry *utils.MetricsRegistry,
+	phones []string, phonesAndAuths map[string]string) {
+	t.Helper()
+	think := phaseThinkTime(t, phase)
+	steps := phase.Session.Steps
+	stats := make([]*utils.RequestStats, len(steps))
+	for i, step := range steps {
+		stats[i] = endpointStats(scenario, phase, run, registry, step.Endpoint)
+	}
+	sessionStats := run.sessions.Stats(utils.Labels{Scenario: utils.ScenarioPhaseLabel(scenario.Name, phase.Name), Endpoint: models.SessionEndpoint})
+	// 第一步之前不等待，之后每个请求前等待一次思考时间
+	wait := func(ctx context.Context, first bool) bool {
+		if first || think == nil {
+			return ctx.Err() == nil
+		}
+		return utils.SleepContext(ctx, think.Next())
+	}
+	runSession := func(ctx context.Context, session *scenarioSession) {
+		start := time.Now()
+		var purchased time.Time
+		first := true
+		for i, step := range steps {
+			succeeded := false
+			for range max(step.Repeat, 1) {
+				if !wait(ctx, first) {
+					sessionStats.RecordWithReason(utils.OutcomeRejected, uint64(time.Since(start).Nanoseconds()), "phase ended")
+					return
+				}
+				first = false
+				if !scenarioEndpoints[step.Endpoint].send(run, stats[i], session) {
+					continue
+				}
+				// 只有purchase成功才算抢到，登录等其他步骤成功不影响完成时间
+				if step.Endpoint == "purchase" {
+					purchased = time.Now()
+				}
+				if step.UntilSuccess {
+					succeeded = true
+					break
+				}
+			}
+			if step.UntilSuccess && !succeeded {
+				sessionStats.RecordWithReason(utils.OutcomeRejected, uint64(time.Since(start).Nanoseconds()), "gave up at "+step.Endpoint)
+				return
+			}
+		}
+		utils.RecordSession(sessionStats, start, purchased, time.Now())
+	}
+
+	duration := time.Duration(phase.DurationSec) * time.Second
+	start := time.Now()
+	sessions := utils.RunSessions(context.Background(), duration, phaseUsers(phase, phones), func(ctx context.Context, user int) {
+		phone := phones[user%len(phones)]
+		runSession(ctx, &scenarioSession{phone: phone, auth: phonesAndAuths[phone]})
+	})
+	end := time.Now()
+	fmt.Printf("phase %s: %d sessions in %v\n", phase.Name, sessions, end.Sub(start).Round(time.Millisecond))
+	for _, s := range append(stats, sessionStats) {
+		s.StartTime, s.EndTime = start, end
+	}
+	// 同一接口可能出现在多个步骤中，只打印一次
+	printed := make(map[string]bool)
+	for i, step := range steps {
+		if !printed[step.Endpoint] {
+			printed[step.Endpoint] = true
+			fmt.Println(stats[i])
+		}
+	}
+	fmt.Println(utils.SessionReport(sessionStats))
+}
+
+// verifyScenario 校验一人一单、超卖和SLO
+func verifyScenario(t *testing.T, scenario *models.Scenario, run *scenarioRun, registry *utils.MetricsRegistry) {
+	t.Helper()
+	verify := scenario.Verify
+	if verify.NoOversell {
+		var success uint64
+		for _, phase := range scenario.Phases {
+			success += registry.Aggregate(utils.Labels{
+				Scenario: utils.ScenarioPhaseLabel(scenario.Name, phase.Name),
+				Endpoint: "purchase",
+				Outcome:  utils.OutcomeSuccess,
+			}).Count
+		}
+		if success > uint64(scenario.Setup.Voucher.Stock) {
+			t.Errorf("oversold: %d successful purchases for stock %d", success, scenario.Setup.Voucher.Stock)
+		}
+	}
+	if verify.OnePersonOneOrder {
+		verifyOnePersonOneOrder(t, run.voucherId, run.fairness)
+	}
+	for _, slo := range verify.SLOs {
+		filters := make([]utils.Labels, 0, len(scenario.Phases))
+		for _, phase := range scenario.Phases {
+			if slo.Phase == "" || slo.Phase == phase.Name {
+				filters = append(filters, utils.Labels{Scenario: utils.ScenarioPhaseLabel(scenario.Name, phase.Name), Endpoint: slo.Endpoint})
+			}
+		}
+		sloRegistry := registry
+		if slo.Endpoint == models.SessionEndpoint {
+			sloRegistry = run.sessions
+		}
+		for _, violation := range utils.EvaluateSLO(sloRegistry, filters, slo) {
+			t.Errorf("SLO violated: %s", violation)
+		}
+	}
+}
+
+// TestScenario 执行场景文件：准备数据、依次运行各阶段、校验结果和SLO、清理
+func TestScenario(t *testing.T) {
+	path := *scenarioFile
+	if path == "" {
+		path = viper.GetString("test.scenario.file")
+	}
+	if path == "" {
+		t.Skip("no scenario file, use --scenario or test.scenario.file")
+	}
+	scenario, err := utils.LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scenario.Validate(scenarioEndpointNames()); err != nil {
+		t.Fatalf("invalid scenario %s: %v", path, err)
+	}
+	if scenario.Setup.ResetStock || scenario.Teardown.ResetStock || scenario.Teardown.DeleteVoucher {
+		requireDestructive(t)
+	}
+	if scenario.Setup.ResetStock || scenario.Teardown.ResetStock {
+		requireRedisCleanup(t)
+	}
+	run, phones, phonesAndAuths := setupScenario(t, scenario)
+	fmt.Printf("scenario %s: %d users, voucher %s\n", scenario.Name, len(phones), run.voucherId)
+
+	var total time.Duration
+	for _, phase := range scenario.Phases {
+		total += time.Duration(phase.DurationSec) * time.Second
+	}
+	registry := utils.NewMetricsRegistry()
+	monitor := startRunMonitor(registry.Stats(utils.Labels{}), total, run.voucherId)
+	for _, phase := range scenario.Phases {
+		runScenarioPhase(t, scenario, phase, run, registry, phones, phonesAndAuths, monitor.selfMonitor())
+	}
+	monitor.stop()
+
+	fmt.Println(registry.Report(utils.Labels{}, utils.LabelScenario, utils.LabelEndpoint, utils.LabelOutcome))
+	if run.sessions.Aggregate(utils.Labels{}).Count > 0 {
+		fmt.Println(run.sessions.Report(utils.Labels{}, utils.LabelScenario, utils.LabelOutcome))
+	}
+	fmt.Println(run.fairness.Analyze())
+	verifyScenario(t, scenario, run, registry)
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"hmdp-go-test/models"
+	"hmdp-go-test/utils"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapshotVoucherIds 返回 test.voucher 和 test.vouchers 中配置的券id
+func snapshotVoucherIds(t *testing.T) []string {
+	t.Helper()
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, voucher := range append([]models.SeckillVoucher{singleVoucherConfig()}, multiVoucherConfigs(t)...) {
+		if voucher.ID == "" || seen[voucher.ID] {
+			continue
+		}
+		// 券id会直接拼进where条件
+		if _, err := strconv.ParseInt(voucher.ID, 10, 64); err != nil {
+			t.Fatalf("invalid voucher id %q", voucher.ID)
+		}
+		seen[voucher.ID] = true
+		ids = append(ids, voucher.ID)
+	}
+	return ids
+}
+
+// snapshotTables 秒杀券和订单只快照配置的券，消息表快照整张表
+func snapshotTables(voucherIds []string) []utils.TableSpec {
+	where := ""
+	if len(voucherIds) > 0 {
+		where = "voucher_id in (" + strings.Join(voucherIds, ", ") + ")"
+	}
+	return []utils.TableSpec{
+		{Name: "tb_seckill_voucher", Where: where},
+		{Name: "tb_voucher_order", Where: where},
+		{Name: viper.GetString("database.mysql.table.producer_message_table_name")},
+		{Name: viper.GetString("database.mysql.table.consumer_message_table_name")},
+	}
+}
+
+func snapshotRedisPatterns(voucherIds []string) []string {
+	patterns := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pattern := range viper.GetStringSlice("test.snapshot.redis_patterns") {
+		for _, id := range voucherIds {
+			expanded := utils.ExpandKeyPattern(pattern, map[string]string{"voucher": id})
+			if !seen[expanded] {
+				seen[expanded] = true
+				patterns = append(patterns, expanded)
+			}
+		}
+	}
+	return patterns
+}
+
+// TestSnapshot 把券、订单、消息表和券的Redis key保存到 test.snapshot.file_name
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	voucherIds := snapshotVoucherIds(t)
+	snapshot := &models.Snapshot{Version: models.SnapshotVersion, CreatedAt: time.Now()}
+	for _, spec := range snapshotTables(voucherIds) {
+		table, err := utils.SnapshotTable(ctx, DBClient, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("snapshot %s: %d rows\n", table.Name, len(table.Rows))
+		snapshot.Tables = append(snapshot.Tables, table)
+	}
+	redisSnapshot, err := utils.SnapshotRedisKeys(ctx, RedisClient, snapshotRedisPatterns(voucherIds), viper.GetInt("test.snapshot.chunk_size"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("snapshot redis: %d keys\n", len(redisSnapshot.Keys))
+	snapshot.Redis = redisSnapshot
+	if err := utils.WriteSnapshot(SnapshotFilePath, snapshot); err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+	fmt.Printf("snapshot saved to %s\n", SnapshotFilePath)
+}
+
+// TestRestoreSnapshot 把 TestSnapshot 保存的数据原样写回，快照之后新增的行和key会被删除
+func TestRestoreSnapshot(t *testing.T) {
+	requireDestructive(t)
+	ctx := context.Background()
+	snapshot, err := utils.ReadSnapshot(SnapshotFilePath)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	chunkSize := viper.GetInt("test.snapshot.chunk_size")
+	if err := utils.RestoreTables(ctx, DBClient, DestructiveGuard, snapshot.Tables, chunkSize); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range snapshot.Tables {
+		fmt.Printf("restored %s: %d rows\n", table.Name, len(table.Rows))
+	}
+	if err := utils.RestoreRedisKeys(ctx, RedisClient, DestructiveGuard, snapshot.Redis, chunkSize); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("restored redis: %d keys, snapshot taken at %s\n", len(snapshot.Redis.Keys), snapshot.CreatedAt.Format(time.DateTime))
+}
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"hmdp-go-test/models"
+	"hmdp-go-test/utils"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// staleAuthsByRedis 查询Redis中token的剩余有效期，不存在或剩余时间不足的视为过期
+func staleAuthsByRedis(t *testing.T, phoneToAuth map[string]string) []string {
+	t.Helper()
+	phones := make([]string, 0, len(phoneToAuth))
+	tokens := make([]string, 0, len(phoneToAuth))
+	for phone, auth := range phoneToAuth {
+		phones = append(phones, phone)
+		tokens = append(tokens, auth)
+	}
+	ttls, err := utils.TokenTTLs(context.Background(), RedisClient, viper.GetString("test.user.token.redis_prefix"),
+		tokens, viper.GetInt("test.user.batch_size"))
+	if err != nil {
+		t.Fatalf("failed to query token ttl: %v", err)
+	}
+	minTTL := time.Duration(viper.GetInt("test.user.token.min_ttl_sec")) * time.Second
+	stale := make([]string, 0)
+	for i, ttl := range ttls {
+		// TTL为-1表示永不过期
+		if ttl == -1 {
+			continue
+		}
+		if ttl < 0 || ttl < minTTL {
+			stale = append(stale, phones[i])
+		}
+	}
+	return stale
+}
+
+// setAuthExpiry 按Redis中token的剩余有效期设置用户池的过期时间，TTL为-1（永不过期）或key不存在时保持未知
+func setAuthExpiry(t *testing.T, pool map[string]models.AuthEntry, phones []string) {
+	t.Helper()
+	tokens := make([]string, len(phones))
+	for i, phone := range phones {
+		tokens[i] = pool[phone].Token
+	}
+	ttls, err := utils.TokenTTLs(context.Background(), RedisClient, viper.GetString("test.user.token.redis_prefix"),
+		tokens, viper.GetInt("test.user.batch_size"))
+	if err != nil {
+		t.Fatalf("failed to query token ttl: %v", err)
+	}
+	now := time.Now()
+	for i, ttl := range ttls {
+		if ttl > 0 {
+			entry := pool[phones[i]]
+			entry.ExpiresAt = now.Add(ttl)
+			pool[phones[i]] = entry
+		}
+	}
+}
+
+// isAuthValidByApi 调用需要登录的接口判断token是否可用
+func isAuthValidByApi(auth string) bool {
+	request := HttpClient.R()
+	request.Header.Set("Authorization", auth)
+	response, err := request.Get(UserMeUrl)
+	if err != nil || response.StatusCode() != 200 {
+		return false
+	}
+	var result models.Result
+	if err := json.Unmarshal(response.Body(), &result); err != nil {
+		return false
+	}
+	return result.Success && result.Data.String() != "null"
+}
+
+func staleAuthsByApi(phoneToAuth map[string]string) []string {
+	var mu sync.Mutex
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, max(viper.GetInt("test.user.batch_size"), 1))
+	stale := make([]string, 0)
+	for phone, auth := range phoneToAuth {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if !isAuthValidByApi(auth) {
+				mu.Lock()
+				stale = append(stale, phone)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return stale
+}
+
+// refreshAuths 校验auth文件中所有token，重新登录过期的用户并改写文件
+func refreshAuths(t *testing.T) {
+	t.Helper()
+	pool, err := utils.ReadAuthPool(AuthsFilePath)
+	if err != nil {
+		t.Fatalf("Failed to parse AuthsFile: %v", err)
+	}
+	phoneToAuth := make(map[string]string, len(pool))
+	for phone, entry := range pool {
+		phoneToAuth[phone] = entry.Token
+	}
+	var stale []string
+	switch mode := viper.GetString("test.user.token.validate_mode"); mode {
+	case "api":
+		stale = staleAuthsByApi(phoneToAuth)
+	case "redis", "":
+		stale = staleAuthsByRedis(t, phoneToAuth)
+	default:
+		t.Fatalf("unknown token validate mode: %s", mode)
+	}
+	sort.Strings(stale)
+
+	retries := viper.GetInt("test.user.max_retries")
+	backoff := time.Duration(viper.GetInt("test.user.retry_backoff_ms")) * time.Millisecond
+	var mu sync.Mutex
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, max(viper.GetInt("test.user.batch_size"), 1))
+	failed := make([]string, 0)
+	refreshed := make([]string, 0, len(stale))
+	for _, phoneString := range stale {
+		phone, err := strconv.ParseInt(phoneString, 10, 64)
+		if err != nil {
+			t.Fatalf("invalid phone in AuthsFile: %s", phoneString)
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var auth string
+			err := utils.RetryWithBackoff(retries, backoff, func() error {
+				var err error
+				err, auth = getAuthWithPhone(phone)
+				return err
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				// 保留原有的用户和UserID，标记为已过期，下次运行时重新登录
+				failed = append(failed, phoneString)
+				entry := pool[phoneString]
+				entry.ExpiresAt = time.Now()
+				pool[phoneString] = entry
+				return
+			}
+			pool[phoneString] = models.AuthEntry{
+				UserID:    pool[phoneString].UserID,
+				Phone:     phoneString,
+				Token:     auth,
+				CreatedAt: time.Now(),
+			}
+			refreshed = append(refreshed, phoneString)
+		}()
+	}
+	wg.Wait()
+	setAuthExpiry(t, pool, refreshed)
+
+	if len(stale) > 0 {
+		if err := utils.WriteAuthPoolAtomic(AuthsFilePath, pool); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
+		}
+	}
+	fmt.Printf("auths valid: %d, refreshed: %d, failed: %d\n",
+		len(pool)-len(stale), len(stale)-len(failed), len(failed))
+	if len(failed) > 0 {
+		t.Errorf("failed to refresh phones: %v", failed)
+	}
+}
+
+func TestValidateAuths(t *testing.T) {
+	refreshAuths(t)
+}
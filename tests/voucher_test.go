@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"hmdp-go-test/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const voucherTimeLayout = "2006-01-02T15:04:05"
+
+func voucherTemplate(t *testing.T) models.VoucherTemplate {
+	t.Helper()
+	var template models.VoucherTemplate
+	if err := viper.UnmarshalKey("test.voucher.provision.template", &template); err != nil {
+		t.Fatalf("failed to parse voucher template: %v", err)
+	}
+	return template
+}
+
+// voucherPayload 根据模板生成创建秒杀券的请求体，时间以 now 为基准
+func voucherPayload(template models.VoucherTemplate, stock int, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"shopId":      template.ShopID,
+		"title":       template.Title,
+		"subTitle":    template.SubTitle,
+		"rules":       template.Rules,
+		"payValue":    template.PayValue,
+		"actualValue": template.ActualValue,
+		"type":        template.Type,
+		"stock":       stock,
+		"beginTime":   now.Add(time.Duration(template.BeginOffsetSec) * time.Second).Format(voucherTimeLayout),
+		"endTime":     now.Add(time.Duration(template.EndOffsetSec) * time.Second).Format(voucherTimeLayout),
+	}
+}
+
+// createSeckillVoucher 调用接口创建秒杀券并返回券ID
+func createSeckillVoucher(authorization string, payload map[string]interface{}) (string, error) {
+	request := HttpClient.R()
+	request.Header.Set("Authorization", authorization)
+	response, err := request.SetBody(payload).Post(AddSeckillVoucherUrl)
+	if err != nil {
+		return "", err
+	}
+	var result models.Result
+	if err := json.Unmarshal(response.Body(), &result); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if !result.Success {
+		return "", fmt.Errorf("create voucher failed: %s", response.Body())
+	}
+	voucherId := result.Data.String()
+	if _, err := strconv.ParseInt(voucherId, 10, 64); err != nil {
+		return "", fmt.Errorf("unexpected voucher id %q", voucherId)
+	}
+	return voucherId, nil
+}
+
+// deleteSeckillVoucher 删除自动创建的秒杀券及其订单和Redis数据
+func deleteSeckillVoucher(t *testing.T, voucherId string) {
+	ctx := context.Background()
+	deleteMysqlOrders(t, voucherId)
+	if _, err := DestructiveGuard.Exec(ctx, DBClient, "delete from tb_seckill_voucher where voucher_id = ?", voucherId); err != nil {
+		t.Errorf("failed to delete seckill voucher %s: %v", voucherId, err)
+	}
+	if _, err := DestructiveGuard.Exec(ctx, DBClient, "delete from tb_voucher where id = ?", voucherId); err != nil {
+		t.Errorf("failed to delete voucher %s: %v", voucherId, err)
+	}
+	if err := DestructiveGuard.AllowRedis(); err != nil {
+		t.Errorf("failed to delete redis keys of voucher %s: %v", voucherId, err)
+		return
+	}
+	// 两个key在Cluster中可能不在同一个slot，分别删除
+	for _, key := range []string{"seckill:stock:" + voucherId, "seckill:order:" + voucherId} {
+		deleted, err := RedisClient.Del(ctx, key).Result()
+		DestructiveGuard.Record(DestructiveGuard.RedisTarget(), "DEL "+key, nil, deleted, err)
+		if err != nil {
+			t.Errorf("failed to delete redis key %s: %v", key, err)
+		}
+	}
+}
+
+// provisionVoucher 开启 test.voucher.provision.enabled 时为本次运行创建新券并替换 voucher.ID，
+// 按配置在测试结束后删除
+func provisionVoucher(t *testing.T, phonesAndAuths map[string]string, voucher *models.SeckillVoucher) {
+	t.Helper()
+	if !viper.GetBool("test.voucher.provision.enabled") {
+		return
+	}
+	var authorization string
+	for phone := range phonesAndAuths {
+		authorization = phonesAndAuths[phone]
+		break
+	}
+	payload := voucherPayload(voucherTemplate(t), voucher.Stock, time.Now())
+	voucherId, err := createSeckillVoucher(authorization, payload)
+	if err != nil {
+		t.Fatalf("failed to provision voucher: %v", err)
+	}
+	fmt.Printf("provisioned voucher %s (stock %d)\n", voucherId, voucher.Stock)
+	voucher.ID = voucherId
+	if viper.GetBool("test.voucher.provision.delete_after") {
+		t.Cleanup(func() {
+			deleteSeckillVoucher(t, voucherId)
+		})
+	}
+}
+
+func TestAddSeckillVoucher(t *testing.T) {
+	phoneToAuth := getPhonesAndAuths(t)
+	var authorization string
+	for phone := range phoneToAuth {
+		authorization = phoneToAuth[phone]
+		break
+	}
+	payload := voucherPayload(voucherTemplate(t), viper.GetInt("test.voucher.stock"), time.Now())
+	voucherId, err := createSeckillVoucher(authorization, payload)
+	assert.Nil(t, err)
+	fmt.Println("voucher id:", voucherId)
+}
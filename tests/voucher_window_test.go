@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"hmdp-go-test/models"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// windowAttempt 一次跨越秒杀时间窗口的购买请求
+type windowAttempt struct {
+	phone    string
+	sent     time.Time
+	received time.Time
+	success  bool
+	orderId  int64 // 成功时返回的订单id
+	failed   bool  // 请求或响应解析失败
+	errorMsg string
+}
+
+type windowPhase int
+
+const (
+	beforeBegin windowPhase = iota
+	atBegin
+	inWindow
+	atEnd
+	afterEnd
+)
+
+func (p windowPhase) String() string {
+	return [...]string{"before begin", "at begin", "in window", "at end", "after end"}[p]
+}
+
+// phase 根据请求的发送和收到响应的时间判断它落在窗口的哪一段，跨越边界的请求单独归类
+func (a *windowAttempt) phase(begin, end time.Time) windowPhase {
+	switch {
+	case a.received.Before(begin):
+		return beforeBegin
+	case a.sent.Before(begin):
+		return atBegin
+	case a.received.Before(end):
+		return inWindow
+	case a.sent.Before(end):
+		return atEnd
+	default:
+		return afterEnd
+	}
+}
+
+func sendWindowPurchase(phone, auth, voucherId string) windowAttempt {
+	request := HttpClient.R()
+	request.Header.Set("Authorization", auth)
+	attempt := windowAttempt{phone: phone, sent: time.Now()}
+	response, err := request.Post(PurchaseSeckillVoucherUrlPrefix + "/" + voucherId)
+	attempt.received = time.Now()
+	if err != nil || response == nil {
+		attempt.failed = true
+		return attempt
+	}
+	var result models.Result
+	if err := json.Unmarshal(response.Body(), &result); err != nil {
+		attempt.failed = true
+		return attempt
+	}
+	attempt.errorMsg = result.ErrorMsg
+	if result.Success {
+		orderId, err := strconv.ParseInt(result.Data.String(), 10, 64)
+		attempt.success = err == nil
+		attempt.orderId = orderId
+	}
+	return attempt
+}
+
+// scheduleWindowPurchases 把用户的购买请求分布在 [start, stop] 上，
+// 其中 burst 个用户恰好在开始时间、burst 个用户恰好在结束时间发出
+func scheduleWindowPurchases(phonesAndAuths map[string]string, voucherId string, start, stop, begin, end time.Time, burst int) []windowAttempt {
+	phones := make([]string, 0, len(phonesAndAuths))
+	for phone := range phonesAndAuths {
+		phones = append(phones, phone)
+	}
+	sort.Strings(phones)
+	burst = min(burst, len(phones)/3)
+	sendTimes := make([]time.Time, len(phones))
+	rest := len(phones) - 2*burst
+	for i := range phones {
+		switch {
+		case i < burst:
+			sendTimes[i] = begin
+		case i < 2*burst:
+			sendTimes[i] = end
+		default:
+			offset := time.Duration(int64(stop.Sub(start)) * int64(i-2*burst) / int64(max(rest, 1)))
+			sendTimes[i] = start.Add(offset)
+		}
+	}
+
+	attempts := make([]windowAttempt, len(phones))
+	wg := &sync.WaitGroup{}
+	wg.Add(len(phones))
+	for i, phone := range phones {
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Until(sendTimes[i]))
+			attempts[i] = sendWindowPurchase(phone, phonesAndAuths[phone], voucherId)
+		}()
+	}
+	wg.Wait()
+	return attempts
+}
+
+func TestSeckillVoucherTimeWindow(t *testing.T) {
+	requireDestructive(t)
+	requireRedisCleanup(t)
+	phonesAndAuths := getPhonesAndAuths(t)
+	beginDelay := time.Duration(viper.GetInt("test.voucher.window.begin_delay_sec")) * time.Second
+	active := time.Duration(viper.GetInt("test.voucher.window.active_sec")) * time.Second
+	tail := time.Duration(viper.GetInt("test.voucher.window.tail_sec")) * time.Second
+	tolerance := time.Duration(viper.GetInt("test.voucher.window.tolerance_ms")) * time.Millisecond
+	notStartedMsg := viper.GetString("test.voucher.window.not_started_msg")
+	endedMsg := viper.GetString("test.voucher.window.ended_msg")
+
+	// 接口的时间精度为秒，开始和结束时间取整秒
+	start := time.Now()
+	begin := start.Add(beginDelay).Truncate(time.Second).Add(time.Second)
+	end := begin.Add(active)
+	stop := end.Add(tail)
+
+	// 库存足够所有用户，保证失败只来自时间窗口
+	stock := len(phonesAndAuths)
+	payload := voucherPayload(voucherTemplate(t), stock, start)
+	payload["beginTime"] = begin.Format(voucherTimeLayout)
+	payload["endTime"] = end.Format(voucherTimeLayout)
+	var authorization string
+	for phone := range phonesAndAuths {
+		authorization = phonesAndAuths[phone]
+		break
+	}
+	voucherId, err := createSeckillVoucher(authorization, payload)
+	if err != nil {
+		t.Fatalf("failed to create voucher: %v", err)
+	}
+	t.Cleanup(func() {
+		deleteSeckillVoucher(t, voucherId)
+	})
+	cleanRedisDatabase(t, voucherId, stock)
+	fmt.Printf("voucher %s window: %s ~ %s\n", voucherId, begin.Format(time.TimeOnly), end.Format(time.TimeOnly))
+
+	attempts := scheduleWindowPurchases(phonesAndAuths, voucherId, start, stop, begin, end,
+		viper.GetInt("test.voucher.window.boundary_burst"))
+
+	// 1. 开始前和结束后不允许成功，拒绝原因需正确
+	type phaseSummary struct {
+		success, rejected, failed int
+		reasons                   map[string]int
+	}
+	summaries := make([]phaseSummary, afterEnd+1)
+	for i := range summaries {
+		summaries[i].reasons = make(map[string]int)
+	}
+	var wrongReasons int
+	for _, a := range attempts {
+		p := a.phase(begin.Add(-tolerance), end.Add(tolerance))
+		summary := &summaries[a.phase(begin, end)]
+		switch {
+		case a.failed:
+			summary.failed++
+			continue
+		case a.success:
+			summary.success++
+		default:
+			summary.rejected++
+			summary.reasons[a.errorMsg]++
+		}
+		if a.success && (p == beforeBegin || p == afterEnd) {
+			t.Errorf("user %s purchased outside window: sent %s, received %s",
+				a.phone, a.sent.Format(time.StampMilli), a.received.Format(time.StampMilli))
+		}
+		if !a.success && (p == beforeBegin && a.errorMsg != notStartedMsg || p == afterEnd && a.errorMsg != endedMsg) {
+			wrongReasons++
+		}
+	}
+	if wrongReasons > 0 {
+		t.Errorf("%d requests outside window got an unexpected rejection reason", wrongReasons)
+	}
+	for p, summary := range summaries {
+		fmt.Printf("%s: success %d, rejected %d, failed %d, reasons %v\n",
+			windowPhase(p), summary.success, summary.rejected, summary.failed, summary.reasons)
+	}
+
+	// 2. 边界处的结果需一致：成功之后不能再出现"尚未开始"，"已经结束"之后不能再出现成功
+	var firstSuccessReceived, lastNotStartedSent, lastSuccessSent, firstEndedReceived time.Time
+	for _, a := range attempts {
+		switch {
+		case a.success:
+			if firstSuccessReceived.IsZero() || a.received.Before(firstSuccessReceived) {
+				firstSuccessReceived = a.received
+			}
+			if a.sent.After(lastSuccessSent) {
+				lastSuccessSent = a.sent
+			}
+		case a.errorMsg == notStartedMsg:
+			if a.sent.After(lastNotStartedSent) {
+				lastNotStartedSent = a.sent
+			}
+		case a.errorMsg == endedMsg:
+			if firstEndedReceived.IsZero() || a.received.Before(firstEndedReceived) {
+				firstEndedReceived = a.received
+			}
+		}
+	}
+	if !firstSuccessReceived.IsZero() && lastNotStartedSent.After(firstSuccessReceived) {
+		t.Errorf("inconsistent begin boundary: not-started rejection sent at %s after a success received at %s",
+			lastNotStartedSent.Format(time.StampMilli), firstSuccessReceived.Format(time.StampMilli))
+	}
+	if !firstEndedReceived.IsZero() && lastSuccessSent.After(firstEndedReceived) {
+		t.Errorf("inconsistent end boundary: success sent at %s after an ended rejection received at %s",
+			lastSuccessSent.Format(time.StampMilli), firstEndedReceived.Format(time.StampMilli))
+	}
+
+	// 3. 订单异步落库，create_time可能晚于结束时间，且与客户端和数据库的时区有关，
+	// 所以不比较create_time，而是要求每个落库的订单都对应一个在窗口内被接受的请求（已在1中按发送和响应时间校验）。
+	// 请求失败的用户可能已经下单，最多允许这么多订单找不到对应的响应
+	waitMysqlOrders(t, voucherId)
+	accepted := make(map[int64]bool)
+	var failed int
+	for _, a := range attempts {
+		if a.success {
+			accepted[a.orderId] = true
+		} else if a.failed {
+			failed++
+		}
+	}
+	rows, err := DBClient.Query("select id from tb_voucher_order where voucher_id = ?", voucherId)
+	if err != nil {
+		t.Fatalf("failed to query orders: %v", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	var unmatched int
+	for rows.Next() {
+		var orderId int64
+		if err := rows.Scan(&orderId); err != nil {
+			t.Fatalf("failed to scan row: %v", err)
+		}
+		if accepted[orderId] {
+			delete(accepted, orderId)
+		} else {
+			unmatched++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("query orders error: %v", err)
+	}
+	if len(accepted) > 0 {
+		t.Errorf("%d accepted purchases have no order in mysql", len(accepted))
+	}
+	if unmatched > failed {
+		t.Errorf("%d orders without an accepted purchase response, only %d requests failed", unmatched, failed)
+	} else if unmatched > 0 {
+		fmt.Printf("%d orders without a response, from %d failed requests\n", unmatched, failed)
+	}
+}
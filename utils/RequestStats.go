@@ -1,67 +1,110 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
-	"sync/atomic"
+	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-type RespType int
-
-const (
-	PurchaseSuccess RespType = iota
-	PurchaseFail
-	ResponseFail
-)
+// RequestsSource 请求速率和延迟采样在时间线中的数据源名称
+const RequestsSource = "requests"
 
+// RequestStats 绑定了场景、接口等固定标签的registry视图，用于记录和报告一类请求
 type RequestStats struct {
-	TotalRequestCount    *atomic.Uint64
-	PurchaseSuccessCount *atomic.Uint64
-	PurchaseFailCount    *atomic.Uint64
-	FailedRequestCount   *atomic.Uint64
-
-	TotalNanoSeconds    *atomic.Uint64
-	PurchaseSuccessNano *atomic.Uint64
-	PurchaseFailNano    *atomic.Uint64
-	FailedNanoSeconds   *atomic.Uint64
+	Registry *MetricsRegistry
+	// 固定标签，Outcome和Reason在记录时填写
+	Labels Labels
 
 	StartTime time.Time
 	EndTime   time.Time
 }
 
-func NewRequestStats() *RequestStats {
+// NewRequestStats 创建使用独立registry的统计
+func NewRequestStats(labels Labels) *RequestStats {
+	return NewMetricsRegistry().Stats(labels)
+}
+
+// Stats 返回绑定了labels的统计视图，同一registry中的多个视图可以分别记录、统一报告
+func (r *MetricsRegistry) Stats(labels Labels) *RequestStats {
 	return &RequestStats{
-		TotalRequestCount:    &atomic.Uint64{},
-		PurchaseSuccessCount: &atomic.Uint64{},
-		PurchaseFailCount:    &atomic.Uint64{},
-		FailedRequestCount:   &atomic.Uint64{},
-		TotalNanoSeconds:     &atomic.Uint64{},
-		PurchaseSuccessNano:  &atomic.Uint64{},
-		PurchaseFailNano:     &atomic.Uint64{},
-		FailedNanoSeconds:    &atomic.Uint64{},
+		Registry: r,
+		Labels:   labels,
 	}
 }
 
-func (s *RequestStats) Record(respType RespType, ns uint64) {
-	s.TotalRequestCount.Add(1)
-	s.TotalNanoSeconds.Add(ns)
-	switch respType {
-	case PurchaseSuccess:
-		{
-			s.PurchaseSuccessCount.Add(1)
-			s.PurchaseSuccessNano.Add(ns)
-		}
-	case PurchaseFail:
-		{
-			s.PurchaseFailCount.Add(1)
-			s.PurchaseFailNano.Add(ns)
+// Begin 标记一个请求开始，返回的函数在请求结束时调用，用于统计进行中的请求数
+func (s *RequestStats) Begin() (done func()) {
+	return s.Registry.Begin(s.Labels)
+}
+
+// InFlight 返回进行中的请求数
+func (s *RequestStats) InFlight() int64 {
+	return s.Registry.InFlight(s.Labels)
+}
+
+func (s *RequestStats) Record(outcome Outcome, ns uint64) {
+	s.RecordWithReason(outcome, ns, "")
+}
+
+// RecordWithReason 记录请求结果，失败时同时记录失败原因
+func (s *RequestStats) RecordWithReason(outcome Outcome, ns uint64, reason string) {
+	labels := s.Labels
+	labels.Outcome = outcome
+	if outcome != OutcomeSuccess {
+		if reason == "" {
+			reason = "unknown"
 		}
-	case ResponseFail:
-		{
-			s.FailedRequestCount.Add(1)
-			s.FailedNanoSeconds.Add(ns)
+		labels.Reason = reason
+	}
+	s.Registry.Record(labels, ns)
+}
+
+func (s *RequestStats) filter(outcome Outcome) Labels {
+	labels := s.Labels
+	labels.Outcome = outcome
+	return labels
+}
+
+// Count 返回指定结果的请求数，outcome为空时返回所有请求数
+func (s *RequestStats) Count(outcome Outcome) uint64 {
+	return s.Registry.Aggregate(s.filter(outcome)).Count
+}
+
+// Latency 返回指定结果的延迟分布，outcome为空时返回所有请求
+func (s *RequestStats) Latency(outcome Outcome) *HistogramSnapshot {
+	return s.Registry.Aggregate(s.filter(outcome))
+}
+
+// Reasons 返回失败原因及其次数的快照
+func (s *RequestStats) Reasons() map[string]uint64 {
+	reasons := make(map[string]uint64)
+	for reason, h := range s.Registry.GroupBy(s.Labels, LabelReason) {
+		if reason != "" {
+			reasons[reason] = h.Count
 		}
 	}
+	return reasons
+}
+
+// ErrorReason 把请求错误归类为简短的失败原因
+func ErrorReason(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "connection refused"
+	case strings.Contains(err.Error(), "connection reset"):
+		return "connection reset"
+	default:
+		return "request error"
+	}
 }
 
 func (s *RequestStats) formatBlock(
@@ -84,11 +127,77 @@ func (s *RequestStats) formatBlock(
 	)
 }
 
+func (s *RequestStats) formatLatency(latency *HistogramSnapshot) string {
+	ms := func(ns uint64) float64 { return float64(ns) / 1e6 }
+	return fmt.Sprintf(
+		"latency(ms):\n  p50: %.2f, p90: %.2f, p99: %.2f, max: %.2f\n",
+		ms(latency.Quantile(0.5)), ms(latency.Quantile(0.9)), ms(latency.Quantile(0.99)), ms(latency.Max),
+	)
+}
+
+func (s *RequestStats) formatReasons() string {
+	reasons := s.Reasons()
+	if len(reasons) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(reasons))
+	for k := range reasons {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if reasons[keys[i]] == reasons[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return reasons[keys[i]] > reasons[keys[j]]
+	})
+	var sb strings.Builder
+	sb.WriteString("failure reasons:\n")
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("  %s: %d\n", k, reasons[k]))
+	}
+	return sb.String()
+}
+
 func (s *RequestStats) String() string {
 	elapsed := uint64(s.EndTime.Sub(s.StartTime).Nanoseconds())
-	return s.formatBlock("total", s.TotalRequestCount.Load(), elapsed) +
-		s.formatBlock("replied", s.PurchaseSuccessCount.Load()+s.PurchaseFailCount.Load(), elapsed) +
-		s.formatBlock("purchase success", s.PurchaseSuccessCount.Load(), s.PurchaseSuccessNano.Load()) +
-		s.formatBlock("purchase failed", s.PurchaseFailCount.Load(), s.PurchaseFailNano.Load()) +
-		s.formatBlock("resp failed", s.FailedRequestCount.Load(), elapsed)
+	success := s.Latency(OutcomeSuccess)
+	rejected := s.Latency(OutcomeRejected)
+	name := s.Labels.Endpoint
+	return s.formatBlock("total", s.Count(""), elapsed) +
+		s.formatBlock("replied", success.Count+rejected.Count, elapsed) +
+		s.formatBlock(name+" success", success.Count, success.Sum) +
+		s.formatBlock(name+" failed", rejected.Count, rejected.Sum) +
+		s.formatBlock("resp failed", s.Count(OutcomeError), elapsed) +
+		s.formatLatency(s.Latency("")) +
+		s.formatReasons()
+}
+
+// Sampler 返回采集请求速率和区间延迟的采样函数，便于在同一时间线上对照服务端压力
+func (s *RequestStats) Sampler() func() (map[string]float64, error) {
+	var mu sync.Mutex
+	var lastTime time.Time
+	var last map[Outcome]*HistogramSnapshot
+	return func() (map[string]float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		current := make(map[Outcome]*HistogramSnapshot)
+		for _, outcome := range []Outcome{OutcomeSuccess, OutcomeRejected, OutcomeError} {
+			current[outcome] = s.Latency(outcome)
+		}
+		values := make(map[string]float64)
+		if !lastTime.IsZero() {
+			seconds := now.Sub(lastTime).Seconds()
+			merged := NewHistogramSnapshot()
+			for outcome, snapshot := range current {
+				delta := snapshot.Sub(last[outcome])
+				values[string(outcome)+"_qps"] = float64(delta.Count) / seconds
+				merged.Merge(delta)
+			}
+			values["p50_ms"] = float64(merged.Quantile(0.5)) / 1e6
+			values["p99_ms"] = float64(merged.Quantile(0.99)) / 1e6
+		}
+		last, lastTime = current, now
+		return values, nil
+	}
 }
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"hmdp-go-test/models"
+	"io"
+	"os"
+	"sort"
+)
+
+// StreamAuthPool 流式读取用户池文件，文件不存在时不调用fn
+func StreamAuthPool(path string, fn func(models.AuthEntry) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	return models.LoadAuthPool(file, fn)
+}
+
+// ReadAuthPool 读取用户池文件，按手机号索引，文件不存在时返回空表
+func ReadAuthPool(path string) (map[string]models.AuthEntry, error) {
+	pool := make(map[string]models.AuthEntry)
+	err := StreamAuthPool(path, func(entry models.AuthEntry) error {
+		pool[entry.Phone] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// ReadAuths 读取用户池文件中的 phone -> authorization，文件不存在时返回空表
+func ReadAuths(path string) (map[string]string, error) {
+	phoneToAuth := make(map[string]string)
+	err := StreamAuthPool(path, func(entry models.AuthEntry) error {
+		phoneToAuth[entry.Phone] = entry.Token
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return phoneToAuth, nil
+}
+
+// IsValidAuth 判断auth文件中的token是否可用
+func IsValidAuth(auth string) bool {
+	return auth != "" && auth != "null"
+}
+
+// WriteAuthPoolAtomic 按手机号排序写入用户池，先写入同目录下的临时文件再重命名，中途失败不会破坏原文件
+func WriteAuthPoolAtomic(path string, pool map[string]models.AuthEntry) error {
+	phones := make([]string, 0, len(pool))
+	for phone := range pool {
+		phones = append(phones, phone)
+	}
+	sort.Strings(phones)
+	return writeFileAtomic(path, func(w io.Writer) error {
+		writer, err := models.NewAuthPoolWriter(w)
+		if err != nil {
+			return err
+		}
+		for _, phone := range phones {
+			if err := writer.Write(pool[phone]); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	})
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 服务端采样在时间线中的数据源名称
+const (
+	RedisSource = "redis"
+	MySQLSource = "mysql"
+)
+
+// redisInfoFields 从 INFO 中采集的字段
+var redisInfoFields = []string{
+	"instantaneous_ops_per_sec",
+	"connected_clients",
+	"blocked_clients",
+	"used_memory",
+	"keyspace_hits",
+	"keyspace_misses",
+}
+
+// parseRedisInfo 解析 INFO 的 key:value 文本
+func parseRedisInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// redisInfo 采集INFO字段，Cluster模式下对所有master求和，nodes为采集到的节点数
+func redisInfo(ctx context.Context, client redis.UniversalClient) (map[string]float64, error) {
+	var mu sync.Mutex
+	values := map[string]float64{"nodes": 0}
+	collect := func(ctx context.Context, node redis.UniversalClient) error {
+		info, err := node.Info(ctx).Result()
+		if err != nil {
+			return err
+		}
+		fields := parseRedisInfo(info)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range redisInfoFields {
+			if v, err := strconv.ParseFloat(fields[key], 64); err == nil {
+				values[key] += v
+			}
+		}
+		values["nodes"]++
+		return nil
+	}
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return collect(ctx, node)
+		})
+		return values, err
+	}
+	return values, collect(ctx, client)
+}
+
+// RedisSampler 返回采集Redis INFO的采样函数，命中数按两次采样间的增量计算命中率
+func RedisSampler(client redis.UniversalClient) func() (map[string]float64, error) {
+	var mu sync.Mutex
+	var lastHits, lastMisses float64
+	first := true
+	return func() (map[string]float64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		values, err := redisInfo(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		values["used_memory_mb"] = values["used_memory"] / (1 << 20)
+		delete(values, "used_memory")
+
+		mu.Lock()
+		defer mu.Unlock()
+		hits, misses := values["keyspace_hits"], values["keyspace_misses"]
+		if !first && hits+misses > lastHits+lastMisses {
+			values["keyspace_hit_rate"] = (hits - lastHits) / (hits + misses - lastHits - lastMisses)
+		}
+		lastHits, lastMisses, first = hits, misses, false
+		return values, nil
+	}
+}
+
+// mysqlStatusCounters 按每秒增量采集的累计计数器，key为输出的指标名
+var mysqlStatusCounters = map[string]string{
+	"Questions":             "qps",
+	"Innodb_row_lock_waits": "row_lock_waits_per_sec",
+	"Innodb_rows_read":      "rows_read_per_sec",
+	"Innodb_rows_inserted":  "rows_inserted_per_sec",
+	"Innodb_rows_updated":   "rows_updated_per_sec",
+	"Innodb_rows_deleted":   "rows_deleted_per_sec",
+}
+
+// mysqlStatusGauges 直接采集当前值的指标
+var mysqlStatusGauges = map[string]string{
+	"Threads_running":               "threads_running",
+	"Threads_connected":             "threads_connected",
+	"Innodb_row_lock_current_waits": "row_lock_current_waits",
+}
+
+// MySQLSampler 返回采集 SHOW GLOBAL STATUS 和 PROCESSLIST 的采样函数
+func MySQLSampler(db *sql.DB) func() (map[string]float64, error) {
+	var mu sync.Mutex
+	var lastTime time.Time
+	last := make(map[string]float64)
+	return func() (map[string]float64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		status, err := globalStatus(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		values := make(map[string]float64)
+		for name, key := range mysqlStatusGauges {
+			if v, ok := status[name]; ok {
+				values[key] = v
+			}
+		}
+
+		mu.Lock()
+		if !lastTime.IsZero() {
+			seconds := now.Sub(lastTime).Seconds()
+			for name, key := range mysqlStatusCounters {
+				if v, ok := status[name]; ok && seconds > 0 {
+					values[key] = (v - last[name]) / seconds
+				}
+			}
+		}
+		for name := range mysqlStatusCounters {
+			last[name] = status[name]
+		}
+		lastTime = now
+		mu.Unlock()
+
+		// 正在执行的连接数和最长执行时间
+		var active, longest float64
+		query := "select count(*), coalesce(max(time), 0) from information_schema.processlist where command <> 'Sleep'"
+		if err := db.QueryRowContext(ctx, query).Scan(&active, &longest); err != nil {
+			return values, fmt.Errorf("query processlist error: %w", err)
+		}
+		values["processlist_active"] = active
+		values["processlist_longest_sec"] = longest
+		return values, nil
+	}
+}
+
+func globalStatus(ctx context.Context, db *sql.DB) (map[string]float64, error) {
+	names := make([]string, 0, len(mysqlStatusCounters)+len(mysqlStatusGauges))
+	for name := range mysqlStatusCounters {
+		names = append(names, "'"+name+"'")
+	}
+	for name := range mysqlStatusGauges {
+		names = append(names, "'"+name+"'")
+	}
+	rows, err := db.QueryContext(ctx, "show global status where Variable_name in ("+strings.Join(names, ", ")+")")
+	if err != nil {
+		return nil, fmt.Errorf("query global status error: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	status := make(map[string]float64)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			status[name] = v
+		}
+	}
+	return status, rows.Err()
+}
+
+// StockSource 秒杀券剩余库存在时间线中的数据源名称
+const StockSource = "stock"
+
+// StockSampler 返回读取秒杀券Redis库存的采样函数，指标名为券id，库存key不存在的券不输出
+func StockSampler(client redis.UniversalClient, voucherIds ...string) func() (map[string]float64, error) {
+	return func() (map[string]float64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		// 每个库存key单独GET，Cluster中不同的key可能在不同节点
+		pipe := client.Pipeline()
+		cmds := make([]*redis.StringCmd, len(voucherIds))
+		for i, id := range voucherIds {
+			cmds[i] = pipe.Get(ctx, "seckill:stock:"+id)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+		values := make(map[string]float64)
+		for i, cmd := range cmds {
+			if v, err := cmd.Float64(); err == nil {
+				values[voucherIds[i]] = v
+			}
+		}
+		return values, nil
+	}
+}
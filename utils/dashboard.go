@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 清屏并把光标移到左上角
+const clearScreen = "\033[H\033[2J"
+
+var dashboardOutcomes = []Outcome{OutcomeSuccess, OutcomeRejected, OutcomeError}
+
+// dashboardTick 一次刷新时各结果的直方图快照
+type dashboardTick struct {
+	time      time.Time
+	snapshots map[Outcome]*HistogramSnapshot
+}
+
+// Dashboard 运行过程中每隔一段时间输出实时的请求速率、滚动延迟、进行中的请求、剩余库存和失败原因。
+// 交互式终端中整屏刷新，否则每次输出一行
+type Dashboard struct {
+	Stats *RequestStats
+	// 读取最新的库存采样，可以为nil
+	Series *TimeSeries
+	// 计划运行时长，为0时不显示剩余时间
+	Duration time.Duration
+	// 滚动分位数的时间窗口
+	Window time.Duration
+	Full   bool
+	Out    io.Writer
+
+	mu      sync.Mutex
+	start   time.Time
+	history []dashboardTick
+}
+
+func NewDashboard(stats *RequestStats, series *TimeSeries, duration time.Duration, out io.Writer, full bool) *Dashboard {
+	return &Dashboard{
+		Stats:    stats,
+		Series:   series,
+		Duration: duration,
+		Window:   5 * time.Second,
+		Full:     full,
+		Out:      out,
+	}
+}
+
+// TerminalOutput 返回交互式终端的输出，CI环境或没有终端时返回false。
+// go test 的标准输出是管道，因此标准输出不是终端时尝试直接写 /dev/tty
+func TerminalOutput() (*os.File, bool) {
+	if os.Getenv("CI") != "" || os.Getenv("TERM") == "dumb" {
+		return nil, false
+	}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return os.Stdout, true
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return nil, false
+	}
+	return tty, true
+}
+
+// Start 每隔interval刷新一次，返回的stop函数会在输出最后一帧后返回
+func (d *Dashboard) Start(interval time.Duration) (stop func()) {
+	d.mu.Lock()
+	d.start = time.Now()
+	d.history = []dashboardTick{d.tick(d.start)}
+	d.mu.Unlock()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				d.refresh(time.Now())
+				return
+			case now := <-ticker.C:
+				d.refresh(now)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+func (d *Dashboard) tick(now time.Time) dashboardTick {
+	t := dashboardTick{time: now, snapshots: make(map[Outcome]*HistogramSnapshot)}
+	for _, outcome := range dashboardOutcomes {
+		t.snapshots[outcome] = d.Stats.Latency(outcome)
+	}
+	return t
+}
+
+func (d *Dashboard) refresh(now time.Time) {
+	d.mu.Lock()
+	frame := d.render(now)
+	d.mu.Unlock()
+	if d.Full {
+		frame = clearScreen + frame
+	}
+	_, _ = io.WriteString(d.Out, frame)
+}
+
+// dashboardView 一帧需要展示的数据
+type dashboardView struct {
+	elapsed   time.Duration
+	remaining time.Duration
+	qps       map[Outcome]float64
+	totals    map[Outcome]uint64
+	p50, p99  float64
+	inFlight  int64
+	stock     map[string]float64
+	reasons   []string
+}
+
+// render 记录一次快照并返回当前帧，调用方需持有d.mu
+func (d *Dashboard) render(now time.Time) string {
+	current := d.tick(now)
+	last := d.history[len(d.history)-1]
+	d.history = append(d.history, current)
+	// 只保留滚动窗口内的快照，窗口起点之前保留一个作为基准
+	for len(d.history) > 2 && now.Sub(d.history[1].time) >= d.Window {
+		d.history = d.history[1:]
+	}
+	base := d.history[0]
+
+	view := dashboardView{
+		elapsed:  now.Sub(d.start),
+		qps:      make(map[Outcome]float64),
+		totals:   make(map[Outcome]uint64),
+		inFlight: d.Stats.InFlight(),
+	}
+	if d.Duration > 0 {
+		view.remaining = max(d.Duration-view.elapsed, 0)
+	}
+	seconds := current.time.Sub(last.time).Seconds()
+	window := NewHistogramSnapshot()
+	for _, outcome := range dashboardOutcomes {
+		snapshot := current.snapshots[outcome]
+		view.totals[outcome] = snapshot.Count
+		if seconds > 0 {
+			view.qps[outcome] = float64(snapshot.Sub(last.snapshots[outcome]).Count) / seconds
+		}
+		window.Merge(snapshot.Sub(base.snapshots[outcome]))
+	}
+	view.p50 = float64(window.Quantile(0.5)) / 1e6
+	view.p99 = float64(window.Quantile(0.99)) / 1e6
+	if d.Series != nil {
+		if sample, ok := d.Series.Latest()[StockSource]; ok {
+			view.stock = sample.Values
+		}
+	}
+	view.reasons = topReasons(d.Stats.Reasons(), 5)
+
+	if d.Full {
+		return d.renderFull(view)
+	}
+	return d.renderLine(view)
+}
+
+func topReasons(reasons map[string]uint64, n int) []string {
+	keys := make([]string, 0, len(reasons))
+	for k := range reasons {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if reasons[keys[i]] == reasons[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return reasons[keys[i]] > reasons[keys[j]]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	for i, k := range keys {
+		keys[i] = fmt.Sprintf("%s=%d", k, reasons[k])
+	}
+	return keys
+}
+
+func formatClock(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+func formatStock(stock map[string]float64) string {
+	ids := make([]string, 0, len(stock))
+	for id := range stock {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s=%.0f", id, stock[id])
+	}
+	return strings.Join(parts, " ")
+}
+
+func (d *Dashboard) renderFull(v dashboardView) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s  elapsed %s", d.Stats.Labels, formatClock(v.elapsed)))
+	if d.Duration > 0 {
+		sb.WriteString(fmt.Sprintf(" / %s, remaining %s", formatClock(d.Duration), formatClock(v.remaining)))
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString(fmt.Sprintf("  %-10s %12s %12s\n", "outcome", "qps", "total"))
+	for _, outcome := range dashboardOutcomes {
+		sb.WriteString(fmt.Sprintf("  %-10s %12.1f %12d\n", outcome, v.qps[outcome], v.totals[outcome]))
+	}
+	sb.WriteString(fmt.Sprintf("\n  latency (last %v): p50 %.2fms, p99 %.2fms\n", d.Window, v.p50, v.p99))
+	sb.WriteString(fmt.Sprintf("  in flight: %d\n", v.inFlight))
+	if len(v.stock) > 0 {
+		sb.WriteString(fmt.Sprintf("  stock left: %s\n", formatStock(v.stock)))
+	}
+	if len(v.reasons) > 0 {
+		sb.WriteString("\n  failure reasons:\n")
+		for _, reason := range v.reasons {
+			sb.WriteString("    " + reason + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func (d *Dashboard) renderLine(v dashboardView) string {
+	var sb strings.Builder
+	sb.WriteString("[" + formatClock(v.elapsed))
+	if d.Duration > 0 {
+		sb.WriteString("/" + formatClock(d.Duration))
+	}
+	sb.WriteString("]")
+	for _, outcome := range dashboardOutcomes {
+		sb.WriteString(fmt.Sprintf(" %s %.1f/s", outcome, v.qps[outcome]))
+	}
+	sb.WriteString(fmt.Sprintf(", p50 %.2fms, p99 %.2fms, in flight %d", v.p50, v.p99, v.inFlight))
+	if len(v.stock) > 0 {
+		sb.WriteString(", stock " + formatStock(v.stock))
+	}
+	if len(v.reasons) > 0 {
+		sb.WriteString(", reasons " + strings.Join(v.reasons, " "))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
@@ -1,11 +1,13 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/spf13/viper"
+	"time"
 )
 
 func InitMySQL() (*sql.DB, error) {
@@ -25,10 +27,38 @@ func InitMySQL() (*sql.DB, error) {
 	return db, err
 }
 
-func InitRedis() *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("database.redis.address"),
-		Password: viper.GetString("database.redis.password"),
-		DB:       viper.GetInt("database.redis.db"),
-	})
+// InitRedis 按 database.redis.mode 创建单机、Cluster或Sentinel客户端
+func InitRedis() (redis.UniversalClient, error) {
+	password := viper.GetString("database.redis.password")
+	addresses := viper.GetStringSlice("database.redis.addresses")
+	if len(addresses) == 0 {
+		addresses = []string{viper.GetString("database.redis.address")}
+	}
+	var client redis.UniversalClient
+	switch mode := viper.GetString("database.redis.mode"); mode {
+	case "", "standalone":
+		client = redis.NewClient(&redis.Options{
+			Addr:     addresses[0],
+			Password: password,
+			DB:       viper.GetInt("database.redis.db"),
+		})
+	case "cluster":
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    addresses,
+			Password: password,
+		})
+	case "sentinel":
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       viper.GetString("database.redis.master_name"),
+			SentinelAddrs:    addresses,
+			SentinelPassword: viper.GetString("database.redis.sentinel_password"),
+			Password:         password,
+			DB:               viper.GetInt("database.redis.db"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", mode)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return client, client.Ping(ctx).Err()
 }
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserOutcome 单个用户在一次抢购中的请求记录
+type UserOutcome struct {
+	Phone         string
+	FirstSendTime time.Time
+	FirstWinTime  time.Time
+	Attempts      uint64
+	Successes     uint64
+	Errors        uint64
+	Rejections    uint64
+}
+
+func (u *UserOutcome) Won() bool {
+	return u.Successes > 0
+}
+
+// FairnessRecorder 记录每个用户的发送时间和结果，用于判断后端是否先到先得
+type FairnessRecorder struct {
+	mu    sync.Mutex
+	users map[string]*UserOutcome
+}
+
+func NewFairnessRecorder() *FairnessRecorder {
+	return &FairnessRecorder{
+		users: make(map[string]*UserOutcome),
+	}
+}
+
+func (f *FairnessRecorder) Record(phone string, sendTime time.Time, outcome Outcome) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[phone]
+	if !ok {
+		u = &UserOutcome{Phone: phone, FirstSendTime: sendTime}
+		f.users[phone] = u
+	}
+	if sendTime.Before(u.FirstSendTime) {
+		u.FirstSendTime = sendTime
+	}
+	u.Attempts++
+	switch outcome {
+	case OutcomeSuccess:
+		u.Successes++
+		if u.FirstWinTime.IsZero() || sendTime.Before(u.FirstWinTime) {
+			u.FirstWinTime = sendTime
+		}
+	case OutcomeRejected:
+		u.Rejections++
+	case OutcomeError:
+		u.Errors++
+	}
+}
+
+// Outcomes 按首次发送时间排序返回所有用户记录的副本
+func (f *FairnessRecorder) Outcomes() []UserOutcome {
+	f.mu.Lock()
+	outcomes := make([]UserOutcome, 0, len(f.users))
+	for _, u := range f.users {
+		outcomes = append(outcomes, *u)
+	}
+	f.mu.Unlock()
+	sort.Slice(outcomes, func(i, j int) bool {
+		if outcomes[i].FirstSendTime.Equal(outcomes[j].FirstSendTime) {
+			return outcomes[i].Phone < outcomes[j].Phone
+		}
+		return outcomes[i].FirstSendTime.Before(outcomes[j].FirstSendTime)
+	})
+	return outcomes
+}
+
+type FairnessReport struct {
+	Users   int
+	Winners int
+	// 发送顺序与是否抢到之间的斯皮尔曼等级相关系数，越接近-1越符合先到先得
+	RankCorrelation float64
+	// 晚发送者抢到而早发送者没抢到的用户对数量
+	InvertedPairs uint64
+	// 在至少一个失败者之后发送却抢到的用户数
+	LateWinners int
+	// 在至少一个成功者之前发送却没抢到的用户数
+	EarlyLosers int
+	// 尝试次数 -> 用户数
+	AttemptsDistribution map[uint64]int
+	// 成功次数 -> 用户数
+	SuccessDistribution map[uint64]int
+}
+
+func (f *FairnessRecorder) Analyze() *FairnessReport {
+	outcomes := f.Outcomes()
+	report := &FairnessReport{
+		Users:                len(outcomes),
+		AttemptsDistribution: make(map[uint64]int),
+		SuccessDistribution:  make(map[uint64]int),
+	}
+	order := make([]float64, len(outcomes))
+	won := make([]float64, len(outcomes))
+	var losersSoFar uint64
+	lastWinner := -1
+	firstLoser := -1
+	for i, u := range outcomes {
+		order[i] = float64(i)
+		report.AttemptsDistribution[u.Attempts]++
+		report.SuccessDistribution[u.Successes]++
+		if u.Won() {
+			won[i] = 1
+			report.Winners++
+			report.InvertedPairs += losersSoFar
+			lastWinner = i
+			if firstLoser >= 0 {
+				report.LateWinners++
+			}
+		} else {
+			losersSoFar++
+			if firstLoser < 0 {
+				firstLoser = i
+			}
+		}
+	}
+	for i := 0; i < lastWinner; i++ {
+		if !outcomes[i].Won() {
+			report.EarlyLosers++
+		}
+	}
+	report.RankCorrelation = spearman(order, won)
+	return report
+}
+
+func (r *FairnessReport) String() string {
+	var sb strings.Builder
+	sb.WriteString("fairness:\n")
+	sb.WriteString(fmt.Sprintf("  users: %d, winners: %d\n", r.Users, r.Winners))
+	sb.WriteString(fmt.Sprintf("  rank correlation (send order vs success): %.4f\n", r.RankCorrelation))
+	sb.WriteString(fmt.Sprintf("  inverted pairs: %d\n", r.InvertedPairs))
+	sb.WriteString(fmt.Sprintf("  late winners: %d, early losers: %d\n", r.LateWinners, r.EarlyLosers))
+	sb.WriteString("  attempts per user:\n")
+	sb.WriteString(formatDistribution(r.AttemptsDistribution))
+	sb.WriteString("  successes per user:\n")
+	sb.WriteString(formatDistribution(r.SuccessDistribution))
+	return sb.String()
+}
+
+func formatDistribution(dist map[uint64]int) string {
+	keys := make([]uint64, 0, len(dist))
+	for k := range dist {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("    %d: %d users\n", k, dist[k]))
+	}
+	return sb.String()
+}
+
+// spearman 计算两组数据的斯皮尔曼等级相关系数，相同值取平均秩
+func spearman(x, y []float64) float64 {
+	if len(x) != len(y) || len(x) < 2 {
+		return 0
+	}
+	return pearson(ranks(x), ranks(y))
+}
+
+func ranks(values []float64) []float64 {
+	idx := make([]int, len(values))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return values[idx[i]] < values[idx[j]] })
+	result := make([]float64, len(values))
+	for i := 0; i < len(idx); {
+		j := i
+		for j+1 < len(idx) && values[idx[j+1]] == values[idx[i]] {
+			j++
+		}
+		avg := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			result[idx[k]] = avg
+		}
+		i = j + 1
+	}
+	return result
+}
+
+func pearson(x, y []float64) float64 {
+	n := float64(len(x))
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varX*varY)
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSpearman(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y []float64
+		want float64
+	}{
+		{"monotonic", []float64{1, 2, 3, 4}, []float64{10, 20, 30, 1000}, 1},
+		{"reversed", []float64{1, 2, 3, 4}, []float64{4, 3, 2, 1}, -1},
+		// y的秩为 1, 2, 3.5, 5, 3.5，r = 8/sqrt(10*9.5)
+		{"ties", []float64{1, 2, 3, 4, 5}, []float64{5, 6, 7, 8, 7}, 0.8207826816681233},
+		// 先发送的两个用户抢到：秩为 3.5, 3.5, 1.5, 1.5，r = -4/sqrt(5*4)
+		{"early winners", []float64{0, 1, 2, 3}, []float64{1, 1, 0, 0}, -0.8944271909999159},
+		{"constant", []float64{1, 2, 3}, []float64{1, 1, 1}, 0},
+		{"single", []float64{1}, []float64{1}, 0},
+		{"empty", nil, nil, 0},
+		{"length mismatch", []float64{1, 2}, []float64{1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, spearman(tt.x, tt.y), 1e-9)
+		})
+	}
+}
+
+func TestRanks(t *testing.T) {
+	assert.Equal(t, []float64{2, 4, 2, 2}, ranks([]float64{1, 5, 1, 1}))
+	assert.Equal(t, []float64{3, 1, 2}, ranks([]float64{9, 1, 3}))
+}
+
+func TestFairnessAnalyze(t *testing.T) {
+	start := time.Now()
+	recorder := NewFairnessRecorder()
+	// 按发送顺序：a失败、b抢到、c失败、d抢到
+	recorder.Record("a", start, OutcomeRejected)
+	recorder.Record("b", start.Add(time.Millisecond), OutcomeSuccess)
+	recorder.Record("c", start.Add(2*time.Millisecond), OutcomeRejected)
+	recorder.Record("c", start.Add(5*time.Millisecond), OutcomeError)
+	recorder.Record("d", start.Add(3*time.Millisecond), OutcomeSuccess)
+
+	report := recorder.Analyze()
+	assert.Equal(t, 4, report.Users)
+	assert.Equal(t, 2, report.Winners)
+	// b在a之后，d在a、c之后
+	assert.Equal(t, uint64(3), report.InvertedPairs)
+	assert.Equal(t, 2, report.LateWinners)
+	assert.Equal(t, 2, report.EarlyLosers)
+	// 秩为 1.5, 3.5, 1.5, 3.5，r = 2/sqrt(5*4)
+	assert.InDelta(t, 0.4472135954999579, report.RankCorrelation, 1e-9)
+	assert.Equal(t, map[uint64]int{1: 3, 2: 1}, report.AttemptsDistribution)
+	assert.Equal(t, map[uint64]int{0: 2, 1: 2}, report.SuccessDistribution)
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 环境标签，prod环境禁止任何破坏性操作
+const (
+	EnvDev     = "dev"
+	EnvStaging = "staging"
+	EnvProd    = "prod"
+)
+
+// ErrNotConfirmed 破坏性操作没有通过 --yes 确认
+var ErrNotConfirmed = errors.New("destructive operation requires --yes")
+
+// Execer *sql.DB 和 *sql.Tx 共有的执行方法
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// auditEntry 审计日志中的一行
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Env       string    `json:"env"`
+	Target    string    `json:"target"`
+	Statement string    `json:"statement"`
+	Args      []any     `json:"args,omitempty"`
+	Affected  int64     `json:"affected"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Guard 破坏性操作（删除、清空、覆盖数据）的守卫：
+// 只允许在非prod环境、目标主机在白名单中、并且通过 --yes 确认时执行，执行的每条语句都写入审计日志
+type Guard struct {
+	Env          string
+	AllowedHosts []string
+	Confirmed    bool
+	// 操作目标，MySQL为一个地址，Redis可能有多个节点
+	MySQLHost  string
+	RedisHosts []string
+
+	mu    sync.Mutex
+	audit *os.File
+}
+
+func NewGuard(env string, allowedHosts []string, confirmed bool) *Guard {
+	return &Guard{Env: env, AllowedHosts: allowedHosts, Confirmed: confirmed}
+}
+
+// OpenAuditLog 以追加方式打开审计日志，每行一个JSON
+func (g *Guard) OpenAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.audit = file
+	return nil
+}
+
+func (g *Guard) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.audit == nil {
+		return nil
+	}
+	err := g.audit.Close()
+	g.audit = nil
+	return err
+}
+
+func hostOnly(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func (g *Guard) hostAllowed(address string) bool {
+	host := hostOnly(address)
+	for _, allowed := range g.AllowedHosts {
+		if strings.EqualFold(hostOnly(allowed), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow 检查能否对hosts执行破坏性操作
+func (g *Guard) allow(hosts ...string) error {
+	switch g.Env {
+	case EnvDev, EnvStaging:
+	case EnvProd:
+		return fmt.Errorf("destructive operations are disabled in env %q", g.Env)
+	default:
+		return fmt.Errorf("unknown env %q, expected %s or %s", g.Env, EnvDev, EnvStaging)
+	}
+	for _, host := range hosts {
+		if !g.hostAllowed(host) {
+			return fmt.Errorf("host %s is not in the destructive operation allow-list", host)
+		}
+	}
+	if !g.Confirmed {
+		return ErrNotConfirmed
+	}
+	return nil
+}
+
+// AllowMySQL 检查能否对MySQL执行破坏性操作
+func (g *Guard) AllowMySQL() error {
+	return g.allow(g.MySQLHost)
+}
+
+// AllowRedis 检查能否对Redis执行破坏性操作
+func (g *Guard) AllowRedis() error {
+	return g.allow(g.RedisHosts...)
+}
+
+// RedisTarget 审计日志中Redis目标的名称
+func (g *Guard) RedisTarget() string {
+	return strings.Join(g.RedisHosts, ",")
+}
+
+// Exec 检查通过后执行一条破坏性SQL，并记录到审计日志
+func (g *Guard) Exec(ctx context.Context, db Execer, query string, args ...any) (sql.Result, error) {
+	if err := g.AllowMySQL(); err != nil {
+		return nil, err
+	}
+	result, err := db.ExecContext(ctx, query, args...)
+	var affected int64
+	if err == nil {
+		affected, _ = result.RowsAffected()
+	}
+	g.Record(g.MySQLHost, query, args, affected, err)
+	return result, err
+}
+
+// ExecSummary 与Exec相同，但审计日志中只记录statement，不记录参数，用于参数为整行数据的批量写入
+func (g *Guard) ExecSummary(ctx context.Context, db Execer, statement string, query string, args ...any) (sql.Result, error) {
+	if err := g.AllowMySQL(); err != nil {
+		return nil, err
+	}
+	result, err := db.ExecContext(ctx, query, args...)
+	var affected int64
+	if err == nil {
+		affected, _ = result.RowsAffected()
+	}
+	g.Record(g.MySQLHost, statement, nil, affected, err)
+	return result, err
+}
+
+// Record 写入一条审计日志，Redis等非SQL的破坏性操作由调用方在执行后记录
+func (g *Guard) Record(target string, statement string, args []any, affected int64, err error) {
+	entry := auditEntry{
+		Time:      time.Now(),
+		Env:       g.Env,
+		Target:    target,
+		Statement: strings.Join(strings.Fields(statement), " "),
+		Args:      args,
+		Affected:  affected,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.audit == nil {
+		return
+	}
+	if err := json.NewEncoder(g.audit).Encode(entry); err != nil {
+		fmt.Printf("write audit log error: %v\n", err)
+	}
+}
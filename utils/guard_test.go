@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGuardAllow(t *testing.T) {
+	guard := NewGuard(EnvDev, []string{"192.168.31.215"}, false)
+	guard.MySQLHost = "192.168.31.215"
+	guard.RedisHosts = []string{"192.168.31.215:6379", "192.168.31.216:6379"}
+
+	// 未确认
+	assert.True(t, errors.Is(guard.AllowMySQL(), ErrNotConfirmed))
+	guard.Confirmed = true
+	assert.NoError(t, guard.AllowMySQL())
+	// 任一Redis节点不在白名单中都拒绝
+	assert.ErrorContains(t, guard.AllowRedis(), "192.168.31.216")
+	guard.AllowedHosts = append(guard.AllowedHosts, "192.168.31.216:6380")
+	assert.NoError(t, guard.AllowRedis())
+
+	guard.Env = EnvProd
+	assert.ErrorContains(t, guard.AllowMySQL(), "disabled")
+	guard.Env = ""
+	assert.ErrorContains(t, guard.AllowMySQL(), "unknown env")
+}
+
+func TestGuardAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	guard := NewGuard(EnvStaging, nil, true)
+	if err := guard.OpenAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	guard.Record("192.168.31.215", "delete from tb_voucher_order\n  where voucher_id = ?", []any{"5"}, 3, nil)
+	guard.Record("192.168.31.215:6379", "UNLINK rate:*", nil, 0, errors.New("timeout"))
+	assert.NoError(t, guard.Close())
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"statement":"delete from tb_voucher_order where voucher_id = ?"`)
+	assert.Contains(t, lines[0], `"env":"staging"`)
+	assert.Contains(t, lines[0], `"affected":3`)
+	assert.Contains(t, lines[1], `"error":"timeout"`)
+}
+
+type fakeExecer struct {
+	query string
+	args  []any
+}
+
+func (e *fakeExecer) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	e.query, e.args = query, args
+	return driverResult(len(args) / 2), nil
+}
+
+type driverResult int64
+
+func (r driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (r driverResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func TestGuardExecSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	guard := NewGuard(EnvDev, []string{"192.168.31.215"}, true)
+	guard.MySQLHost = "192.168.31.215"
+	if err := guard.OpenAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	db := &fakeExecer{}
+	query := "insert into tb_user (phone, nick_name) values (?, ?), (?, ?)"
+	_, err := guard.ExecSummary(context.Background(), db, "insert into tb_user (phone, nick_name) values /* 2 rows */", query,
+		"13800000000", "user_a", "13800000001", "user_b")
+	assert.NoError(t, err)
+	assert.Equal(t, query, db.query)
+	assert.Len(t, db.args, 4)
+	assert.NoError(t, guard.Close())
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSpace(string(data))
+	assert.Contains(t, line, `"statement":"insert into tb_user (phone, nick_name) values /* 2 rows */"`)
+	assert.Contains(t, line, `"affected":2`)
+	assert.NotContains(t, line, "13800000000")
+	assert.NotContains(t, line, `"args"`)
+
+	// 未通过检查时不执行
+	guard.Env = EnvProd
+	db = &fakeExecer{}
+	_, err = guard.ExecSummary(context.Background(), db, "insert", query)
+	assert.ErrorContains(t, err, "disabled")
+	assert.Empty(t, db.query)
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// 每个2的幂区间再细分为16个桶，相对误差约6%
+const (
+	subBucketBits = 4
+	subBuckets    = 1 << subBucketBits
+	numBuckets    = (64-subBucketBits+1)*subBuckets + subBuckets
+	// 每个分片约8KB，限制分片数避免序列多时占用过多内存
+	maxShards     = 8
+	cacheLineSize = 64
+)
+
+// histogramShard 单个分片，计数器之间用填充隔开，避免不同分片落在同一缓存行
+type histogramShard struct {
+	count   atomic.Uint64
+	sum     atomic.Uint64
+	max     atomic.Uint64
+	_       [cacheLineSize - 24]byte
+	buckets [numBuckets]atomic.Uint64
+	_       [cacheLineSize]byte
+}
+
+// Histogram 并发安全的对数线性直方图，用于记录纳秒级延迟。
+// 写入时按当前P选择分片，同一个P上的worker总是写同一个分片，不同P之间基本不会争用同一缓存行；
+// 读取时合并所有分片得到HistogramSnapshot
+type Histogram struct {
+	shards []histogramShard
+	mask   uint64
+}
+
+// shardHint 分片编号，通过sync.Pool取用：Pool在每个P上有独立的缓存，Get基本总是拿到当前P上次Put回来的同一个hint
+type shardHint struct {
+	index uint64
+}
+
+var (
+	nextShardHint atomic.Uint64
+	shardHints    = sync.Pool{New: func() any { return &shardHint{index: nextShardHint.Add(1) - 1} }}
+)
+
+func NewHistogram() *Histogram {
+	n := 1
+	for n < min(runtime.GOMAXPROCS(0), maxShards) {
+		n <<= 1
+	}
+	return &Histogram{
+		shards: make([]histogramShard, n),
+		mask:   uint64(n - 1),
+	}
+}
+
+func bucketIndex(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - 1 - subBucketBits
+	sub := (v >> shift) & (subBuckets - 1)
+	return (shift+1)<<subBucketBits + int(sub)
+}
+
+func bucketLowerBound(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := i>>subBucketBits - 1
+	sub := uint64(i & (subBuckets - 1))
+	return (subBuckets | sub) << shift
+}
+
+func storeMax(max *atomic.Uint64, v uint64) {
+	for {
+		old := max.Load()
+		if v <= old || max.CompareAndSwap(old, v) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) Record(v uint64) {
+	hint := shardHints.Get().(*shardHint)
+	shard := &h.shards[hint.index&h.mask]
+	shardHints.Put(hint)
+	shard.buckets[bucketIndex(v)].Add(1)
+	shard.count.Add(1)
+	shard.sum.Add(v)
+	storeMax(&shard.max, v)
+}
+
+func (h *Histogram) Count() uint64 {
+	var count uint64
+	for i := range h.shards {
+		count += h.shards[i].count.Load()
+	}
+	return count
+}
+
+func (h *Histogram) Sum() uint64 {
+	var sum uint64
+	for i := range h.shards {
+		sum += h.shards[i].sum.Load()
+	}
+	return sum
+}
+
+func (h *Histogram) Max() uint64 {
+	var m uint64
+	for i := range h.shards {
+		m = max(m, h.shards[i].max.Load())
+	}
+	return m
+}
+
+// HistogramSnapshot 直方图某一时刻的快照，两个快照相减得到一段时间内的分布
+type HistogramSnapshot struct {
+	Buckets []uint64
+	Count   uint64
+	Sum     uint64
+	// 快照相减后为这段时间内最大值的上界
+	Max uint64
+}
+
+func NewHistogramSnapshot() *HistogramSnapshot {
+	return &HistogramSnapshot{Buckets: make([]uint64, numBuckets)}
+}
+
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	snapshot := NewHistogramSnapshot()
+	h.addTo(snapshot)
+	return snapshot
+}
+
+// addTo 把所有分片累加到s，不分配新的直方图
+func (h *Histogram) addTo(s *HistogramSnapshot) {
+	for i := range h.shards {
+		shard := &h.shards[i]
+		for j := range s.Buckets {
+			n := shard.buckets[j].Load()
+			s.Buckets[j] += n
+			s.Count += n
+		}
+		s.Sum += shard.sum.Load()
+		s.Max = max(s.Max, shard.max.Load())
+	}
+}
+
+// Merge 把other的数据累加到s
+func (s *HistogramSnapshot) Merge(other *HistogramSnapshot) {
+	for i, n := range other.Buckets {
+		s.Buckets[i] += n
+	}
+	s.Count += other.Count
+	s.Sum += other.Sum
+	s.Max = max(s.Max, other.Max)
+}
+
+// Sub 返回从prev到s之间新增的数据，prev为nil时返回s
+func (s *HistogramSnapshot) Sub(prev *HistogramSnapshot) *HistogramSnapshot {
+	if prev == nil {
+		return s
+	}
+	delta := &HistogramSnapshot{Buckets: make([]uint64, len(s.Buckets)), Max: s.Max}
+	for i := range s.Buckets {
+		delta.Buckets[i] = s.Buckets[i] - prev.Buckets[i]
+		delta.Count += delta.Buckets[i]
+	}
+	delta.Sum = s.Sum - prev.Sum
+	return delta
+}
+
+// Quantile 返回第q分位数（0~1）所在桶的中点，最大值所在桶直接返回最大值
+func (s *HistogramSnapshot) Quantile(q float64) uint64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.Count))
+	if rank >= s.Count {
+		rank = s.Count - 1
+	}
+	var seen uint64
+	for i, n := range s.Buckets {
+		seen += n
+		if seen > rank {
+			if i+1 >= numBuckets {
+				return s.Max
+			}
+			lower := bucketLowerBound(i)
+			mid := lower + (bucketLowerBound(i+1)-lower)/2
+			return min(mid, s.Max)
+		}
+	}
+	return s.Max
+}
+
+// Quantile 返回第q分位数（0~1）所在桶的中点，最大值所在桶直接返回最大值
+func (h *Histogram) Quantile(q float64) uint64 {
+	return h.Snapshot().Quantile(q)
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadPhase 一个压测阶段
+type LoadPhase struct {
+	Name     string
+	Duration time.Duration
+	// 每秒发出的请求数，为0时不限速，由Concurrency个worker循环发送
+	Rate float64
+	// 同时进行的请求数上限
+	Concurrency int
+}
+
+// RunPhase 按阶段配置反复调用fire，Duration结束后停止发出新请求并等待进行中的请求完成，返回发出的请求数。
+// 限速时按固定间隔调度，并发槽位不足时会阻塞调度，等待时间记录到monitor（可以为nil）
+func RunPhase(ctx context.Context, phase LoadPhase, monitor *SelfMonitor, fire func(ctx context.Context)) int64 {
+	concurrency := max(phase.Concurrency, 1)
+	phaseCtx, cancel := context.WithTimeout(ctx, phase.Duration)
+	defer cancel()
+	var fired atomic.Int64
+	wg := &sync.WaitGroup{}
+
+	if phase.Rate <= 0 {
+		for range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for phaseCtx.Err() == nil {
+					fired.Add(1)
+					fire(ctx)
+				}
+			}()
+		}
+		wg.Wait()
+		return fired.Load()
+	}
+
+	sem := make(chan struct{}, concurrency)
+	interval := time.Duration(float64(time.Second) / phase.Rate)
+	start := time.Now()
+	for i := 0; ; i++ {
+		// 按计划时间发出，避免累计误差
+		next := start.Add(time.Duration(i) * interval)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-phaseCtx.Done():
+			timer.Stop()
+			wg.Wait()
+			return fired.Load()
+		case <-timer.C:
+		}
+		waitStart := time.Now()
+		select {
+		case sem <- struct{}{}:
+		case <-phaseCtx.Done():
+			wg.Wait()
+			return fired.Load()
+		}
+		if monitor != nil {
+			monitor.RecordSemaphoreWait(time.Since(waitStart))
+		}
+		fired.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fire(ctx)
+		}()
+	}
+}
+
+// RunVirtualUsers 启动users个虚拟用户，每个用户循环调用fire并在两次请求之间等待思考时间，
+// duration结束后不再发出新请求并等待进行中的请求完成，返回发出的请求数
+func RunVirtualUsers(ctx context.Context, duration time.Duration, users int, think ThinkTime, fire func(ctx context.Context, user int)) int64 {
+	phaseCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	var fired atomic.Int64
+	wg := &sync.WaitGroup{}
+	for user := range max(users, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 先等待一次思考时间，避免所有用户在同一时刻发出第一个请求
+			for SleepContext(phaseCtx, think.Next()) {
+				fired.Add(1)
+				fire(ctx, user)
+			}
+		}()
+	}
+	wg.Wait()
+	return fired.Load()
+}
+
+// WeightedPicker 按权重随机选择下标
+type WeightedPicker struct {
+	cumulative []float64
+}
+
+func NewWeightedPicker(weights []float64) (*WeightedPicker, error) {
+	cumulative := make([]float64, len(weights))
+	var total float64
+	for i, w := range weights {
+		if w < 0 {
+			return nil, fmt.Errorf("negative weight %v at %d", w, i)
+		}
+		total += w
+		cumulative[i] = total
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("weights must have a positive sum")
+	}
+	return &WeightedPicker{cumulative: cumulative}, nil
+}
+
+func (p *WeightedPicker) Pick() int {
+	r := rand.Float64() * p.cumulative[len(p.cumulative)-1]
+	i := sort.Search(len(p.cumulative), func(i int) bool { return p.cumulative[i] > r })
+	return min(i, len(p.cumulative)-1)
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Outcome 一次请求的结果，与具体接口无关
+type Outcome string
+
+const (
+	// OutcomeSuccess 接口返回成功
+	OutcomeSuccess Outcome = "success"
+	// OutcomeRejected 接口有响应但业务失败，如库存不足、重复下单
+	OutcomeRejected Outcome = "rejected"
+	// OutcomeError 请求失败或响应无法解析
+	OutcomeError Outcome = "error"
+)
+
+type LabelKey string
+
+const (
+	LabelScenario LabelKey = "scenario"
+	LabelEndpoint LabelKey = "endpoint"
+	LabelVoucher  LabelKey = "voucher"
+	LabelOutcome  LabelKey = "outcome"
+	LabelReason   LabelKey = "reason"
+)
+
+var AllLabelKeys = []LabelKey{LabelScenario, LabelEndpoint, LabelVoucher, LabelOutcome, LabelReason}
+
+// Labels 一条指标序列的标签，查询时空字段表示匹配任意值
+type Labels struct {
+	Scenario string
+	Endpoint string
+	Voucher  string
+	Outcome  Outcome
+	Reason   string
+}
+
+func (l Labels) Get(key LabelKey) string {
+	switch key {
+	case LabelScenario:
+		return l.Scenario
+	case LabelEndpoint:
+		return l.Endpoint
+	case LabelVoucher:
+		return l.Voucher
+	case LabelOutcome:
+		return string(l.Outcome)
+	case LabelReason:
+		return l.Reason
+	}
+	return ""
+}
+
+// Matches 判断l是否满足filter中所有非空的标签
+func (l Labels) Matches(filter Labels) bool {
+	for _, key := range AllLabelKeys {
+		if v := filter.Get(key); v != "" && v != l.Get(key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l Labels) String() string {
+	parts := make([]string, 0, len(AllLabelKeys))
+	for _, key := range AllLabelKeys {
+		if v := l.Get(key); v != "" {
+			parts = append(parts, fmt.Sprintf("%s=%q", key, v))
+		}
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// MetricsRegistry 按标签组织的请求计数和延迟直方图，任意场景和接口都可以记录到同一个registry中。
+// 序列保存在sync.Map中，已存在的序列读取时不加锁
+type MetricsRegistry struct {
+	series   sync.Map // Labels -> *Histogram
+	inFlight sync.Map // Labels -> *atomic.Int64
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+func (r *MetricsRegistry) histogram(labels Labels) *Histogram {
+	if h, ok := r.series.Load(labels); ok {
+		return h.(*Histogram)
+	}
+	h, _ := r.series.LoadOrStore(labels, NewHistogram())
+	return h.(*Histogram)
+}
+
+// Record 记录一次请求及其耗时（纳秒）
+func (r *MetricsRegistry) Record(labels Labels, ns uint64) {
+	r.histogram(labels).Record(ns)
+}
+
+// Begin 标记一个请求开始，返回的函数在请求结束时调用
+func (r *MetricsRegistry) Begin(labels Labels) (done func()) {
+	gauge, ok := r.inFlight.Load(labels)
+	if !ok {
+		gauge, _ = r.inFlight.LoadOrStore(labels, &atomic.Int64{})
+	}
+	gauge.(*atomic.Int64).Add(1)
+	return func() {
+		gauge.(*atomic.Int64).Add(-1)
+	}
+}
+
+// InFlight 返回匹配filter的正在进行中的请求数
+func (r *MetricsRegistry) InFlight(filter Labels) int64 {
+	var total int64
+	r.inFlight.Range(func(key, value any) bool {
+		if key.(Labels).Matches(filter) {
+			total += value.(*atomic.Int64).Load()
+		}
+		return true
+	})
+	return total
+}
+
+// EachInFlight 按标签顺序遍历所有进行中请求数的序列，每个序列只给出它自己的计数
+func (r *MetricsRegistry) EachInFlight(fn func(labels Labels, inFlight int64)) {
+	type gauge struct {
+		labels Labels
+		value  int64
+	}
+	gauges := make([]gauge, 0)
+	r.inFlight.Range(func(key, value any) bool {
+		gauges = append(gauges, gauge{labels: key.(Labels), value: value.(*atomic.Int64).Load()})
+		return true
+	})
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].labels.String() < gauges[j].labels.String() })
+	for _, g := range gauges {
+		fn(g.labels, g.value)
+	}
+}
+
+// Each 按标签顺序遍历所有序列
+func (r *MetricsRegistry) Each(fn func(labels Labels, latency *Histogram)) {
+	labels := make([]Labels, 0)
+	r.series.Range(func(key, value any) bool {
+		labels = append(labels, key.(Labels))
+		return true
+	})
+	sort.Slice(labels, func(i, j int) bool { return labels[i].String() < labels[j].String() })
+	for _, l := range labels {
+		fn(l, r.histogram(l))
+	}
+}
+
+// Aggregate 汇总所有匹配filter的序列
+func (r *MetricsRegistry) Aggregate(filter Labels) *HistogramSnapshot {
+	merged := NewHistogramSnapshot()
+	r.series.Range(func(key, value any) bool {
+		if key.(Labels).Matches(filter) {
+			value.(*Histogram).addTo(merged)
+		}
+		return true
+	})
+	return merged
+}
+
+// GroupBy 按给定标签对匹配filter的序列分组汇总，分组键为各标签值以逗号连接
+func (r *MetricsRegistry) GroupBy(filter Labels, keys ...LabelKey) map[string]*HistogramSnapshot {
+	groups := make(map[string]*HistogramSnapshot)
+	r.series.Range(func(key, value any) bool {
+		labels := key.(Labels)
+		if !labels.Matches(filter) {
+			return true
+		}
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i] = labels.Get(key)
+		}
+		group := strings.Join(values, ",")
+		if _, ok := groups[group]; !ok {
+			groups[group] = NewHistogramSnapshot()
+		}
+		value.(*Histogram).addTo(groups[group])
+		return true
+	})
+	return groups
+}
+
+// Report 以表格形式输出按标签分组的请求数和延迟分位数
+func (r *MetricsRegistry) Report(filter Labels, keys ...LabelKey) string {
+	groups := r.GroupBy(filter, keys...)
+	names := make([]string, 0, len(groups))
+	var total uint64
+	for name, h := range groups {
+		names = append(names, name)
+		total += h.Count
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if groups[names[i]].Count == groups[names[j]].Count {
+			return names[i] < names[j]
+		}
+		return groups[names[i]].Count > groups[names[j]].Count
+	})
+	headers := make([]string, len(keys))
+	for i, key := range keys {
+		headers[i] = string(key)
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s %s:\n", strings.Join(headers, ","), filter))
+	ms := func(ns uint64) float64 { return float64(ns) / 1e6 }
+	for _, name := range names {
+		h := groups[name]
+		sb.WriteString(fmt.Sprintf("  %s: count %d (%.1f%%), p50 %.2fms, p99 %.2fms\n",
+			name, h.Count, 100*float64(h.Count)/float64(max(total, 1)), ms(h.Quantile(0.5)), ms(h.Quantile(0.99))))
+	}
+	return sb.String()
+}
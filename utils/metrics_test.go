@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 旧实现中所有worker共享同一组atomic计数器，作为对照
+func BenchmarkSharedAtomicRecordParallel(b *testing.B) {
+	var count, sum atomic.Uint64
+	b.RunParallel(func(pb *testing.PB) {
+		var v uint64
+		for pb.Next() {
+			v++
+			count.Add(1)
+			sum.Add(v)
+		}
+	})
+}
+
+// 只有一个分片的直方图，相当于不分片时所有worker争用同一组计数器
+func BenchmarkUnshardedHistogramRecordParallel(b *testing.B) {
+	h := &Histogram{shards: make([]histogramShard, 1)}
+	b.RunParallel(func(pb *testing.PB) {
+		var v uint64
+		for pb.Next() {
+			v += 1000
+			h.Record(v)
+		}
+	})
+}
+
+func BenchmarkHistogramRecordParallel(b *testing.B) {
+	h := NewHistogram()
+	b.RunParallel(func(pb *testing.PB) {
+		var v uint64
+		for pb.Next() {
+			v += 1000
+			h.Record(v)
+		}
+	})
+	if h.Count() != uint64(b.N) {
+		b.Fatalf("count %d, want %d", h.Count(), b.N)
+	}
+}
+
+func BenchmarkRequestStatsRecordParallel(b *testing.B) {
+	stats := NewRequestStats(Labels{Scenario: "bench", Endpoint: "purchase", Voucher: "5"})
+	b.RunParallel(func(pb *testing.PB) {
+		var v uint64
+		for pb.Next() {
+			v += 1000
+			if v%3000 == 0 {
+				stats.RecordWithReason(OutcomeRejected, v, "库存不足")
+			} else {
+				stats.Record(OutcomeSuccess, v)
+			}
+		}
+	})
+	if stats.Count("") != uint64(b.N) {
+		b.Fatalf("count %d, want %d", stats.Count(""), b.N)
+	}
+}
+
+func TestBucketIndex(t *testing.T) {
+	tests := []struct {
+		v     uint64
+		index int
+	}{
+		{0, 0}, {15, 15}, {16, 16}, {31, 31},
+		// 32~63 每个桶宽2
+		{32, 32}, {33, 32}, {34, 33}, {63, 47},
+		{64, 48},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.index, bucketIndex(tt.v), "v=%d", tt.v)
+	}
+	assert.Less(t, bucketIndex(math.MaxUint64), numBuckets)
+
+	// 每个值落在 [下界, 下一个桶的下界) 之间，桶宽不超过下界的1/16
+	for _, v := range []uint64{1, 17, 100, 1000, 12345, 1 << 20, 999_999_999, 1<<40 + 12345} {
+		i := bucketIndex(v)
+		lower, upper := bucketLowerBound(i), bucketLowerBound(i+1)
+		assert.LessOrEqual(t, lower, v)
+		assert.Less(t, v, upper)
+		if v >= subBuckets {
+			assert.LessOrEqual(t, upper-lower, lower/subBuckets)
+		}
+	}
+	// 最大值所在桶之后的桶不会被使用
+	for i := 1; i <= bucketIndex(math.MaxUint64); i++ {
+		assert.Less(t, bucketLowerBound(i-1), bucketLowerBound(i), "bucket %d", i)
+		assert.Equal(t, i, bucketIndex(bucketLowerBound(i)), "bucket %d", i)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	assert.Equal(t, uint64(0), h.Quantile(0.5))
+
+	h.Record(12345)
+	// 只有一个值时分位数不超过最大值
+	assert.Equal(t, uint64(12345), h.Quantile(0.99))
+
+	h = NewHistogram()
+	for v := uint64(1); v <= 10000; v++ {
+		h.Record(v * 1000)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		want := q * 10000 * 1000
+		assert.InEpsilon(t, want, float64(h.Quantile(q)), 1.0/subBuckets, "q=%v", q)
+	}
+	assert.Equal(t, uint64(10000*1000), h.Quantile(1))
+}
+
+func TestHistogramMergeShards(t *testing.T) {
+	h := &Histogram{shards: make([]histogramShard, 4), mask: 3}
+	// 不同goroutine的写入可能落在不同分片，读取时合并
+	wg := &sync.WaitGroup{}
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				h.Record(uint64(w*1000 + i))
+			}
+		}()
+	}
+	wg.Wait()
+	// 直接写入指定分片
+	h.shards[3].buckets[bucketIndex(1<<30)].Add(1)
+	h.shards[3].count.Add(1)
+	h.shards[3].sum.Add(1 << 30)
+	storeMax(&h.shards[3].max, 1<<30)
+
+	snapshot := h.Snapshot()
+	assert.Equal(t, uint64(8001), snapshot.Count)
+	assert.Equal(t, h.Count(), snapshot.Count)
+	assert.Equal(t, h.Sum(), snapshot.Sum)
+	assert.Equal(t, uint64(1<<30), snapshot.Max)
+
+	merged := NewHistogramSnapshot()
+	merged.Merge(snapshot)
+	merged.Merge(snapshot)
+	assert.Equal(t, 2*snapshot.Count, merged.Count)
+	assert.Equal(t, 2*snapshot.Sum, merged.Sum)
+	assert.Equal(t, snapshot.Quantile(0.5), merged.Quantile(0.5))
+
+	registry := NewMetricsRegistry()
+	registry.Record(Labels{Endpoint: "a", Outcome: OutcomeSuccess}, 100)
+	registry.Record(Labels{Endpoint: "a", Outcome: OutcomeRejected}, 200)
+	registry.Record(Labels{Endpoint: "b", Outcome: OutcomeSuccess}, 300)
+	all := registry.Aggregate(Labels{})
+	assert.Equal(t, uint64(3), all.Count)
+	assert.Equal(t, uint64(600), all.Sum)
+	assert.Equal(t, uint64(300), all.Max)
+	groups := registry.GroupBy(Labels{}, LabelEndpoint)
+	assert.Equal(t, uint64(2), groups["a"].Count)
+	assert.Equal(t, uint64(1), groups["b"].Count)
+}
+
+func TestEachInFlight(t *testing.T) {
+	registry := NewMetricsRegistry()
+	// 不带券标签的序列会匹配带券标签的序列，导出时不能重复计数
+	done := registry.Begin(Labels{Endpoint: "purchase"})
+	registry.Begin(Labels{Endpoint: "purchase", Voucher: "5"})
+	registry.Begin(Labels{Endpoint: "purchase", Voucher: "5"})
+	gauges := make(map[string]int64)
+	registry.EachInFlight(func(labels Labels, inFlight int64) {
+		gauges[labels.Voucher] = inFlight
+	})
+	assert.Equal(t, map[string]int64{"": 1, "5": 2}, gauges)
+	assert.Equal(t, int64(3), registry.InFlight(Labels{Endpoint: "purchase"}))
+	done()
+	assert.Equal(t, int64(2), registry.InFlight(Labels{}))
+}
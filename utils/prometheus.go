@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prometheusBuckets 延迟直方图导出的桶上界（秒）
+var prometheusBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// PrometheusExporter 以Prometheus文本格式导出registry中的请求指标，以及时间线中各数据源最近一次的采样
+type PrometheusExporter struct {
+	Registry *MetricsRegistry
+	// 可选，导出为 hmdp_{source}_{metric} 形式的gauge，库存导出为 hmdp_stock{voucher=...}
+	Series *TimeSeries
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+func formatPrometheusLabels(labels Labels, extra ...string) string {
+	parts := make([]string, 0, len(AllLabelKeys)+len(extra)/2)
+	for _, key := range AllLabelKeys {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, key, escapeLabelValue(labels.Get(key))))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteTo 写出当前所有指标
+func (e *PrometheusExporter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	written := int64(0)
+	write := func(format string, args ...any) {
+		n, _ := fmt.Fprintf(bw, format, args...)
+		written += int64(n)
+	}
+
+	type series struct {
+		labels   Labels
+		snapshot *HistogramSnapshot
+	}
+	all := make([]series, 0)
+	if e.Registry != nil {
+		e.Registry.Each(func(labels Labels, latency *Histogram) {
+			all = append(all, series{labels: labels, snapshot: latency.Snapshot()})
+		})
+	}
+
+	write("# HELP hmdp_requests_total Requests sent by the load generator.\n")
+	write("# TYPE hmdp_requests_total counter\n")
+	for _, s := range all {
+		write("hmdp_requests_total%s %d\n", formatPrometheusLabels(s.labels), s.snapshot.Count)
+	}
+
+	write("# HELP hmdp_request_duration_seconds Request latency seen by the load generator.\n")
+	write("# TYPE hmdp_request_duration_seconds histogram\n")
+	for _, s := range all {
+		var cumulative uint64
+		next := 0
+		for _, le := range prometheusBuckets {
+			// 只累加整个落在上界以内的桶
+			bound := uint64(le * 1e9)
+			for next < numBuckets-1 && bucketLowerBound(next+1) <= bound {
+				cumulative += s.snapshot.Buckets[next]
+				next++
+			}
+			write("hmdp_request_duration_seconds_bucket%s %d\n",
+				formatPrometheusLabels(s.labels, "le", formatFloat(le)), cumulative)
+		}
+		write("hmdp_request_duration_seconds_bucket%s %d\n", formatPrometheusLabels(s.labels, "le", "+Inf"), s.snapshot.Count)
+		write("hmdp_request_duration_seconds_sum%s %s\n", formatPrometheusLabels(s.labels), formatFloat(float64(s.snapshot.Sum)/1e9))
+		write("hmdp_request_duration_seconds_count%s %d\n", formatPrometheusLabels(s.labels), s.snapshot.Count)
+	}
+
+	if e.Registry != nil {
+		write("# HELP hmdp_requests_in_flight Requests sent and not yet answered.\n")
+		write("# TYPE hmdp_requests_in_flight gauge\n")
+		e.Registry.EachInFlight(func(labels Labels, inFlight int64) {
+			write("hmdp_requests_in_flight%s %d\n", formatPrometheusLabels(labels), inFlight)
+		})
+	}
+
+	if e.Series != nil {
+		latest := e.Series.Latest()
+		sources := make([]string, 0, len(latest))
+		for source := range latest {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			values := latest[source].Values
+			keys := make([]string, 0, len(values))
+			for k := range values {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			// 库存的key是券id，导出为一个带voucher标签的gauge，便于按券聚合
+			if source == StockSource {
+				write("# TYPE hmdp_stock gauge\n")
+				for _, id := range keys {
+					write("hmdp_stock{voucher=\"%s\"} %s\n", escapeLabelValue(id), formatFloat(values[id]))
+				}
+				continue
+			}
+			for _, k := range keys {
+				name := invalidMetricChars.ReplaceAllString("hmdp_"+source+"_"+k, "_")
+				write("# TYPE %s gauge\n", name)
+				write("%s %s\n", name, formatFloat(values[k]))
+			}
+		}
+	}
+	return written, bw.Flush()
+}
+
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = e.WriteTo(w)
+}
+
+// MetricsServer 暴露 /metrics 的HTTP服务
+type MetricsServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// StartMetricsServer 在addr上启动 /metrics 服务，addr的端口为0时随机选择端口
+func StartMetricsServer(addr string, exporter *PrometheusExporter) (*MetricsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("metrics server error: %v\n", err)
+		}
+	}()
+	return &MetricsServer{server: server, listener: listener}, nil
+}
+
+func (s *MetricsServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *MetricsServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsServerScrape(t *testing.T) {
+	registry := NewMetricsRegistry()
+	stats := registry.Stats(Labels{Scenario: "seckill", Endpoint: "purchase", Voucher: "5"})
+	stats.Record(OutcomeSuccess, uint64(3*time.Millisecond))
+	stats.Record(OutcomeSuccess, uint64(30*time.Millisecond))
+	stats.RecordWithReason(OutcomeRejected, uint64(2*time.Millisecond), `库存"不足"`)
+	series := NewTimeSeries()
+	series.Add(GeneratorSource, time.Now(), map[string]float64{"goroutines": 42})
+	series.Add(StockSource, time.Now(), map[string]float64{"5": 17, "12": 0})
+
+	server, err := StartMetricsServer("127.0.0.1:0", &PrometheusExporter{Registry: registry, Series: series})
+	if err != nil {
+		t.Fatalf("failed to start metrics server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	response, err := http.Get("http://" + server.Addr() + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	text := string(body)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/plain"))
+
+	success := `scenario="seckill",endpoint="purchase",voucher="5",outcome="success",reason=""`
+	assert.Contains(t, text, "# TYPE hmdp_requests_total counter\n")
+	assert.Contains(t, text, "hmdp_requests_total{"+success+"} 2\n")
+	assert.Contains(t, text, `reason="库存\"不足\""} 1`)
+	assert.Contains(t, text, "hmdp_request_duration_seconds_bucket{"+success+`,le="0.01"} 1`+"\n")
+	assert.Contains(t, text, "hmdp_request_duration_seconds_bucket{"+success+`,le="0.05"} 2`+"\n")
+	assert.Contains(t, text, "hmdp_request_duration_seconds_bucket{"+success+`,le="+Inf"} 2`+"\n")
+	assert.Contains(t, text, "hmdp_request_duration_seconds_count{"+success+"} 2\n")
+	assert.Contains(t, text, "hmdp_generator_goroutines 42\n")
+	assert.Contains(t, text, "# TYPE hmdp_stock gauge\nhmdp_stock{voucher=\"12\"} 0\nhmdp_stock{voucher=\"5\"} 17\n")
+	assert.NotContains(t, text, "hmdp_stock_")
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryStop 用户停止重试的原因
+type RetryStop string
+
+const (
+	RetryStopSuccess     RetryStop = "success"
+	RetryStopSoldOut     RetryStop = "sold out"
+	RetryStopDuplicate   RetryStop = "duplicate"
+	RetryStopMaxAttempts RetryStop = "max attempts"
+	// RetryStopTimeout 抢购时间结束
+	RetryStopTimeout RetryStop = "timeout"
+)
+
+// Backoff 第n次重试前等待 Initial*Multiplier^n，不超过Max（为0时不限制），
+// Jitter为0~1，按比例随机缩短等待时间，避免所有用户同时重试
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+func (b Backoff) Delay(retry int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	d := float64(b.Initial) * math.Pow(multiplier, float64(retry))
+	if b.Max > 0 {
+		d = min(d, float64(b.Max))
+	}
+	if b.Jitter > 0 {
+		d -= d * min(b.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// RetryPolicy 用户抢购失败后的重试策略，按响应的errorMsg判断是否已经没有必要重试
+type RetryPolicy struct {
+	// 最多尝试次数，0表示不限制，直到ctx结束
+	MaxAttempts int
+	Backoff     Backoff
+	// errorMsg 包含其中任意一项时视为已售罄
+	SoldOutMessages []string
+	// errorMsg 包含其中任意一项时视为重复下单
+	DuplicateMessages []string
+}
+
+// Classify 按一次尝试的结果判断是否停止，返回空字符串表示继续重试。请求错误总是重试
+func (p RetryPolicy) Classify(outcome Outcome, errorMsg string) RetryStop {
+	switch outcome {
+	case OutcomeSuccess:
+		return RetryStopSuccess
+	case OutcomeRejected:
+		if containsAny(errorMsg, p.SoldOutMessages) {
+			return RetryStopSoldOut
+		}
+		if containsAny(errorMsg, p.DuplicateMessages) {
+			return RetryStopDuplicate
+		}
+	}
+	return ""
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if sub != "" && strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryUntilDone 反复调用attempt直到成功、售罄、重复下单、用完次数或ctx结束，返回尝试次数和停止原因。
+// attempt 返回本次的结果和失败时的errorMsg。acquire不为nil时每次尝试前先占用一个并发槽位，尝试结束后释放，
+// 退避等待期间不占用槽位；ctx结束前没有拿到槽位时停止，这次不计入尝试次数
+func RetryUntilDone(ctx context.Context, policy RetryPolicy, acquire func(ctx context.Context) (release func(), ok bool), attempt func() (Outcome, string)) (int, RetryStop) {
+	attempts := 0
+	for {
+		if ctx.Err() != nil {
+			return attempts, RetryStopTimeout
+		}
+		release := func() {}
+		if acquire != nil {
+			var ok bool
+			if release, ok = acquire(ctx); !ok {
+				return attempts, RetryStopTimeout
+			}
+		}
+		attempts++
+		outcome, errorMsg := attempt()
+		release()
+		if stop := policy.Classify(outcome, errorMsg); stop != "" {
+			return attempts, stop
+		}
+		if policy.MaxAttempts > 0 && attempts >= policy.MaxAttempts {
+			return attempts, RetryStopMaxAttempts
+		}
+		if !SleepContext(ctx, policy.Backoff.Delay(attempts-1)) {
+			return attempts, RetryStopTimeout
+		}
+	}
+}
+
+// RetryRecorder 汇总每个用户的尝试次数和停止原因
+type RetryRecorder struct {
+	mu       sync.Mutex
+	attempts map[uint64]int
+	stops    map[RetryStop]int
+	requests uint64
+	success  uint64
+	// 抢购结束前一次请求都没有发出的用户，不计入用户数和尝试次数分布
+	notStarted int
+}
+
+func NewRetryRecorder() *RetryRecorder {
+	return &RetryRecorder{
+		attempts: make(map[uint64]int),
+		stops:    make(map[RetryStop]int),
+	}
+}
+
+func (r *RetryRecorder) Record(attempts int, stop RetryStop) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempts == 0 {
+		r.notStarted++
+		return
+	}
+	r.attempts[uint64(attempts)]++
+	r.stops[stop]++
+	r.requests += uint64(attempts)
+	if stop == RetryStopSuccess {
+		r.success++
+	}
+}
+
+// WastedRequests 没有抢到券的请求数，即总请求数减去成功数
+func (r *RetryRecorder) WastedRequests() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests - r.success
+}
+
+func (r *RetryRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var users int
+	for _, n := range r.stops {
+		users += n
+	}
+	var sb strings.Builder
+	sb.WriteString("retry:\n")
+	sb.WriteString(fmt.Sprintf("  users: %d, requests: %d, wasted requests: %d (%.2f%%)\n",
+		users, r.requests, r.requests-r.success, float64(r.requests-r.success)*100/float64(max(r.requests, 1))))
+	sb.WriteString(fmt.Sprintf("  attempts per user: mean %.2f\n", float64(r.requests)/float64(max(users, 1))))
+	if r.notStarted > 0 {
+		sb.WriteString(fmt.Sprintf("  users without any request: %d\n", r.notStarted))
+	}
+	sb.WriteString(formatDistribution(r.attempts))
+	stops := make([]RetryStop, 0, len(r.stops))
+	for stop := range r.stops {
+		stops = append(stops, stop)
+	}
+	sort.Slice(stops, func(i, j int) bool {
+		if r.stops[stops[i]] == r.stops[stops[j]] {
+			return stops[i] < stops[j]
+		}
+		return r.stops[stops[i]] > r.stops[stops[j]]
+	})
+	sb.WriteString("  stopped by:\n")
+	for _, stop := range stops {
+		sb.WriteString(fmt.Sprintf("    %s: %d users\n", stop, r.stops[stop]))
+	}
+	return sb.String()
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryUntilDone(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:       5,
+		Backoff:           Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond, Multiplier: 2},
+		SoldOutMessages:   []string{"库存不足"},
+		DuplicateMessages: []string{"重复下单"},
+	}
+	// 依次返回的结果，用完后一直返回error
+	attempt := func(responses ...Outcome) func() (Outcome, string) {
+		messages := map[Outcome]string{OutcomeRejected: "库存不足！"}
+		return func() (Outcome, string) {
+			if len(responses) == 0 {
+				return OutcomeError, ""
+			}
+			outcome := responses[0]
+			responses = responses[1:]
+			return outcome, messages[outcome]
+		}
+	}
+	recorder := NewRetryRecorder()
+	record := func(attempts int, stop RetryStop) (int, RetryStop) {
+		recorder.Record(attempts, stop)
+		return attempts, stop
+	}
+	attempts, stop := record(RetryUntilDone(context.Background(), policy, nil, attempt(OutcomeError, OutcomeError, OutcomeSuccess)))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, RetryStopSuccess, stop)
+	attempts, stop = record(RetryUntilDone(context.Background(), policy, nil, attempt(OutcomeError, OutcomeRejected)))
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, RetryStopSoldOut, stop)
+	attempts, stop = record(RetryUntilDone(context.Background(), policy, nil, attempt()))
+	assert.Equal(t, 5, attempts)
+	assert.Equal(t, RetryStopMaxAttempts, stop)
+
+	assert.Equal(t, RetryStopDuplicate, policy.Classify(OutcomeRejected, "不允许重复下单"))
+	assert.Equal(t, RetryStop(""), policy.Classify(OutcomeRejected, "秒杀尚未开始！"))
+
+	// 不限次数时直到ctx结束
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, stop = RetryUntilDone(ctx, RetryPolicy{Backoff: Backoff{Initial: time.Millisecond}}, nil, attempt())
+	assert.Equal(t, RetryStopTimeout, stop)
+
+	// 3个用户共10次请求，1次成功
+	assert.Equal(t, uint64(9), recorder.WastedRequests())
+	assert.Contains(t, recorder.String(), "users: 3, requests: 10, wasted requests: 9")
+	assert.Equal(t, 2*time.Millisecond, policy.Backoff.Delay(5))
+}
+
+func TestRetryUntilDoneSlots(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: Backoff{Initial: 5 * time.Millisecond}}
+	sem := make(chan struct{}, 2)
+	acquire := func(ctx context.Context) (func(), bool) {
+		select {
+		case sem <- struct{}{}:
+			return func() { <-sem }, true
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+	// 10个用户共用2个槽位，退避期间释放槽位，所有用户都能用完3次尝试
+	var inFlight, peak atomic.Int64
+	recorder := NewRetryRecorder()
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder.Record(RetryUntilDone(context.Background(), policy, acquire, func() (Outcome, string) {
+				n := inFlight.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				inFlight.Add(-1)
+				return OutcomeError, ""
+			}))
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, peak.Load(), int64(2))
+	assert.Contains(t, recorder.String(), "users: 10, requests: 30")
+
+	// 槽位一直被占用时，ctx结束的用户没有发出请求，不计入用户数
+	sem <- struct{}{}
+	sem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	attempts, stop := RetryUntilDone(ctx, policy, acquire, func() (Outcome, string) {
+		t.Error("attempt without a slot")
+		return OutcomeSuccess, ""
+	})
+	assert.Equal(t, 0, attempts)
+	assert.Equal(t, RetryStopTimeout, stop)
+	recorder.Record(attempts, stop)
+	assert.Contains(t, recorder.String(), "users: 10, requests: 30")
+	assert.Contains(t, recorder.String(), "users without any request: 1")
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"sync"
+)
+
+// RedisCleanupResult 一个模式的清理结果
+type RedisCleanupResult struct {
+	Pattern string
+	// 匹配到的key数
+	Matched int64
+	// 实际删除的key数，dry run时为0
+	Deleted int64
+	// dry run时列出匹配到的key
+	Keys []string
+}
+
+// ExpandKeyPattern 把模式中的 ${name} 替换为vars中的值
+func ExpandKeyPattern(pattern string, vars map[string]string) string {
+	for name, value := range vars {
+		pattern = strings.ReplaceAll(pattern, "${"+name+"}", value)
+	}
+	return pattern
+}
+
+// isGlobPattern 判断模式中是否包含SCAN MATCH的通配符，不含通配符的模式直接按key处理
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// ScanKeys 用SCAN游标遍历匹配pattern的key，每批调用一次fn。
+// Cluster模式下在每个master上并发扫描，fn可能被并发调用
+func ScanKeys(ctx context.Context, client redis.UniversalClient, pattern string, count int64, fn func(keys []string) error) error {
+	scan := func(ctx context.Context, node redis.UniversalClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := node.Scan(ctx, cursor, pattern, count).Result()
+			if err != nil {
+				return fmt.Errorf("scan %q error: %w", pattern, err)
+			}
+			if len(keys) > 0 {
+				if err := fn(keys); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scan(ctx, node)
+		})
+	}
+	return scan(ctx, client)
+}
+
+// unlinkKeys 逐个key流水线UNLINK，Cluster客户端会按slot把命令发往对应节点，避免CROSSSLOT错误
+func unlinkKeys(ctx context.Context, client redis.UniversalClient, keys []string) (int64, error) {
+	pipe := client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Unlink(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("unlink %d keys error: %w", len(keys), err)
+	}
+	var deleted int64
+	for _, cmd := range cmds {
+		deleted += cmd.Val()
+	}
+	return deleted, nil
+}
+
+// keyBatcher 把扫描到的key攒成不超过size个一批交给flush，可以被多个扫描并发调用
+type keyBatcher struct {
+	mu      sync.Mutex
+	size    int
+	pending []string
+	flush   func(keys []string) error
+}
+
+func newKeyBatcher(size int, flush func(keys []string) error) *keyBatcher {
+	size = max(size, 1)
+	return &keyBatcher{size: size, pending: make([]string, 0, size), flush: flush}
+}
+
+func (b *keyBatcher) Add(keys []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		b.pending = append(b.pending, key)
+		if len(b.pending) >= b.size {
+			if err := b.flushLocked(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush 提交剩余不满一批的key
+func (b *keyBatcher) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushLocked()
+}
+
+func (b *keyBatcher) flushLocked() error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	err := b.flush(b.pending)
+	b.pending = b.pending[:0]
+	return err
+}
+
+// CleanupRedisKeys 清理匹配patterns的key，每batchSize个key执行一次UNLINK。
+// dryRun时只列出匹配的key而不删除；出错时返回已完成模式的结果和错误
+func CleanupRedisKeys(ctx context.Context, client redis.UniversalClient, patterns []string, batchSize int, dryRun bool) ([]RedisCleanupResult, error) {
+	batchSize = max(batchSize, 1)
+	results := make([]RedisCleanupResult, 0, len(patterns))
+	for _, pattern := range patterns {
+		result := RedisCleanupResult{Pattern: pattern}
+		batcher := newKeyBatcher(batchSize, func(keys []string) error {
+			result.Matched += int64(len(keys))
+			if dryRun {
+				result.Keys = append(result.Keys, keys...)
+				return nil
+			}
+			deleted, err := unlinkKeys(ctx, client, keys)
+			result.Deleted += deleted
+			return err
+		})
+		collect := batcher.Add
+
+		var err error
+		if isGlobPattern(pattern) {
+			err = ScanKeys(ctx, client, pattern, int64(batchSize), collect)
+		} else {
+			// 不含通配符的模式不需要扫描整个keyspace
+			var exists int64
+			exists, err = client.Exists(ctx, pattern).Result()
+			if err == nil && exists > 0 {
+				err = collect([]string{pattern})
+			}
+		}
+		if err == nil {
+			err = batcher.Flush()
+		}
+		if err != nil {
+			return results, fmt.Errorf("cleanup %q error: %w", pattern, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
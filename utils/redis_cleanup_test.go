@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestExpandKeyPattern(t *testing.T) {
+	vars := map[string]string{"voucher": "5", "user": "1010"}
+	assert.Equal(t, "seckill:order:5", ExpandKeyPattern("seckill:order:${voucher}", vars))
+	assert.Equal(t, "lock:order:1010:5", ExpandKeyPattern("lock:order:${user}:${voucher}", vars))
+	// 未知变量和不带变量的模式保持原样
+	assert.Equal(t, "rate:${ip}:*", ExpandKeyPattern("rate:${ip}:*", vars))
+	assert.Equal(t, "{rate:*}", ExpandKeyPattern("{rate:*}", vars))
+
+	assert.True(t, isGlobPattern("rate:*"))
+	assert.True(t, isGlobPattern("seckill:order:?"))
+	assert.True(t, isGlobPattern("seckill:[ab]"))
+	assert.False(t, isGlobPattern("seckill:order:5"))
+}
+
+func TestKeyBatcher(t *testing.T) {
+	var batches [][]string
+	batcher := newKeyBatcher(3, func(keys []string) error {
+		batches = append(batches, append([]string(nil), keys...))
+		return nil
+	})
+	assert.NoError(t, batcher.Add([]string{"a", "b"}))
+	assert.Empty(t, batches)
+	assert.NoError(t, batcher.Add([]string{"c", "d", "e", "f", "g"}))
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"d", "e", "f"}}, batches)
+	assert.NoError(t, batcher.Flush())
+	assert.NoError(t, batcher.Flush())
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"g"}}, batches)
+
+	// 并发扫描时每批都不超过size，所有key都被提交一次
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	concurrent := newKeyBatcher(7, func(keys []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.LessOrEqual(t, len(keys), 7)
+		for _, key := range keys {
+			seen[key]++
+		}
+		return nil
+	})
+	wg := &sync.WaitGroup{}
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 25 {
+				assert.NoError(t, concurrent.Add([]string{fmt.Sprintf("%d:%d", w, i)}))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, concurrent.Flush())
+	assert.Len(t, seen, 100)
+
+	failing := newKeyBatcher(0, func(keys []string) error { return errors.New("unlink failed") })
+	assert.ErrorContains(t, failing.Add([]string{"a"}), "unlink failed")
+}
@@ -0,0 +1,13 @@
+package utils
+
+import "time"
+
+// RetryWithBackoff 执行fn直到成功或用完重试次数，每次重试的等待时间翻倍
+func RetryWithBackoff(retries int, backoff time.Duration, fn func() error) error {
+	err := fn()
+	for i := 0; i < retries && err != nil; i++ {
+		time.Sleep(backoff << i)
+		err = fn()
+	}
+	return err
+}
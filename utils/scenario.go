@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"hmdp-go-test/models"
+)
+
+// LoadScenario 读取场景文件，格式由扩展名决定（yaml、json等）
+func LoadScenario(path string) (*models.Scenario, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read scenario %s error: %w", path, err)
+	}
+	var scenario models.Scenario
+	// 拼错的key会被静默忽略，导致SLO等配置不生效，所以不允许未知的key
+	if err := v.UnmarshalExact(&scenario); err != nil {
+		return nil, fmt.Errorf("parse scenario %s error: %w", path, err)
+	}
+	return &scenario, nil
+}
+
+// ScenarioPhaseLabel 场景中每个阶段的指标使用 {场景}/{阶段} 作为场景标签
+func ScenarioPhaseLabel(scenario, phase string) string {
+	return scenario + "/" + phase
+}
+
+// EvaluateSLO 汇总所有匹配filters的序列并检查SLO，返回不满足的目标。
+// 会话放弃时记录的是放弃前的时长，不是完成时间，所以会话的延迟分位数只统计成功的会话
+func EvaluateSLO(registry *MetricsRegistry, filters []Labels, slo models.ScenarioSLO) []string {
+	latency := NewHistogramSnapshot()
+	counts := make(map[Outcome]uint64)
+	var total uint64
+	for _, filter := range filters {
+		for _, outcome := range []Outcome{OutcomeSuccess, OutcomeRejected, OutcomeError} {
+			f := filter
+			f.Outcome = outcome
+			snapshot := registry.Aggregate(f)
+			counts[outcome] += snapshot.Count
+			total += snapshot.Count
+			if slo.Endpoint != models.SessionEndpoint || outcome == OutcomeSuccess {
+				latency.Merge(snapshot)
+			}
+		}
+	}
+	name := slo.Endpoint
+	if slo.Phase != "" {
+		name += "@" + slo.Phase
+	}
+	violations := make([]string, 0)
+	ms := func(ns uint64) float64 { return float64(ns) / 1e6 }
+	if slo.P50Ms != nil && ms(latency.Quantile(0.5)) > *slo.P50Ms {
+		violations = append(violations, fmt.Sprintf("%s: p50 %.2fms > %.2fms", name, ms(latency.Quantile(0.5)), *slo.P50Ms))
+	}
+	if slo.P99Ms != nil && ms(latency.Quantile(0.99)) > *slo.P99Ms {
+		violations = append(violations, fmt.Sprintf("%s: p99 %.2fms > %.2fms", name, ms(latency.Quantile(0.99)), *slo.P99Ms))
+	}
+	rate := func(n uint64) float64 { return float64(n) / float64(max(total, 1)) }
+	if slo.MaxErrorRate != nil && rate(counts[OutcomeError]) > *slo.MaxErrorRate {
+		violations = append(violations, fmt.Sprintf("%s: error rate %.4f > %.4f", name, rate(counts[OutcomeError]), *slo.MaxErrorRate))
+	}
+	if slo.MinSuccessRate != nil && rate(counts[OutcomeSuccess]) < *slo.MinSuccessRate {
+		violations = append(violations, fmt.Sprintf("%s: success rate %.4f < %.4f", name, rate(counts[OutcomeSuccess]), *slo.MinSuccessRate))
+	}
+	if slo.MinSuccess != nil && counts[OutcomeSuccess] < *slo.MinSuccess {
+		violations = append(violations, fmt.Sprintf("%s: %d successes < %d", name, counts[OutcomeSuccess], *slo.MinSuccess))
+	}
+	if slo.MaxSuccess != nil && counts[OutcomeSuccess] > *slo.MaxSuccess {
+		violations = append(violations, fmt.Sprintf("%s: %d successes > %d", name, counts[OutcomeSuccess], *slo.MaxSuccess))
+	}
+	return violations
+}
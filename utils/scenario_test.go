@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"hmdp-go-test/models"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	scenario, err := LoadScenario("../scenarios/flash_sale.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, scenario.Validate(map[string]bool{"purchase": true, "me": true}))
+	assert.Len(t, scenario.Phases, 2)
+	assert.Equal(t, 100, scenario.Setup.Voucher.Stock)
+	assert.Equal(t, 200, scenario.Phases[1].Concurrency)
+	if assert.NotNil(t, scenario.Verify.SLOs[0].P99Ms) {
+		assert.Equal(t, 500.0, *scenario.Verify.SLOs[0].P99Ms)
+	}
+	assert.Nil(t, scenario.Verify.SLOs[0].P50Ms)
+	assert.ErrorContains(t, scenario.Validate(map[string]bool{"purchase": true}), "unknown endpoint")
+
+	mixed, err := LoadScenario("../scenarios/mixed_traffic.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, mixed.Validate(map[string]bool{"purchase": true, "shop": true, "voucher_list": true}))
+	if assert.NotNil(t, mixed.Phases[1].ThinkTime) {
+		assert.Equal(t, 3000, mixed.Phases[1].ThinkTime.MaxMs)
+	}
+	assert.Equal(t, "browse and purchase", mixed.Phases[1].Name)
+	// 每人限购一单，库存不小于用户数才不会在基线阶段售罄
+	assert.GreaterOrEqual(t, mixed.Setup.Voucher.Stock, mixed.Setup.Users)
+
+	session, err := LoadScenario("../scenarios/session.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, session.Validate(map[string]bool{"login": true, "purchase": true, "shop": true, "voucher_list": true}))
+	if assert.NotNil(t, session.Phases[0].Session) {
+		steps := session.Phases[0].Session.Steps
+		assert.Len(t, steps, 4)
+		assert.Equal(t, 5, steps[3].Repeat)
+		assert.True(t, steps[3].UntilSuccess)
+	}
+	assert.Equal(t, models.ThinkTimeNormal, session.Phases[0].ThinkTime.Distribution)
+}
+
+func TestLoadScenarioUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "typo.yaml")
+	// p99_ms 拼成了 p99ms
+	content := `name: "typo"
+phases:
+  - name: "rush"
+    duration_sec: 10
+    concurrency: 10
+    mix:
+      - endpoint: "purchase"
+        weight: 1
+verify:
+  slo:
+    - endpoint: "purchase"
+      p99ms: 500
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadScenario(path)
+	assert.ErrorContains(t, err, "p99ms")
+}
+
+func TestEvaluateSLO(t *testing.T) {
+	registry := NewMetricsRegistry()
+	stats := registry.Stats(Labels{Scenario: ScenarioPhaseLabel("s", "rush"), Endpoint: "purchase"})
+	for range 98 {
+		stats.Record(OutcomeSuccess, uint64(10*time.Millisecond))
+	}
+	stats.RecordWithReason(OutcomeError, uint64(time.Second), "timeout")
+	stats.RecordWithReason(OutcomeRejected, uint64(time.Second), "库存不足")
+
+	p99, errorRate, minSuccess := 100.0, 0.001, uint64(98)
+	filters := []Labels{{Scenario: ScenarioPhaseLabel("s", "rush"), Endpoint: "purchase"}}
+	violations := EvaluateSLO(registry, filters, models.ScenarioSLO{Endpoint: "purchase", P99Ms: &p99, MaxErrorRate: &errorRate, MinSuccess: &minSuccess})
+	assert.Len(t, violations, 2)
+	assert.Contains(t, violations[0], "p99")
+	assert.Contains(t, violations[1], "error rate 0.0100")
+}
+
+func TestEvaluateSessionSLO(t *testing.T) {
+	registry := NewMetricsRegistry()
+	labels := Labels{Scenario: ScenarioPhaseLabel("s", "browse"), Endpoint: models.SessionEndpoint}
+	stats := registry.Stats(labels)
+	for range 90 {
+		stats.Record(OutcomeSuccess, uint64(2*time.Second))
+	}
+	// 放弃的会话时长远小于完成时间，不能拉低分位数
+	for range 10 {
+		stats.RecordWithReason(OutcomeRejected, uint64(10*time.Millisecond), "gave up at purchase")
+	}
+
+	p50, minSuccessRate := 1000.0, 0.95
+	violations := EvaluateSLO(registry, []Labels{labels}, models.ScenarioSLO{Endpoint: models.SessionEndpoint, P50Ms: &p50, MinSuccessRate: &minSuccessRate})
+	assert.Len(t, violations, 2)
+	assert.Contains(t, violations[0], "p50")
+	assert.Contains(t, violations[1], "success rate 0.9000")
+
+	p50 = 3000
+	assert.Empty(t, EvaluateSLO(registry, []Labels{labels}, models.ScenarioSLO{Endpoint: models.SessionEndpoint, P50Ms: &p50}))
+}
+
+func TestRunVirtualUsers(t *testing.T) {
+	var calls [3]atomic.Int64
+	think := UniformThinkTime{Min: 20 * time.Millisecond, Max: 30 * time.Millisecond}
+	fired := RunVirtualUsers(context.Background(), 200*time.Millisecond, 3, think, func(ctx context.Context, user int) {
+		calls[user].Add(1)
+	})
+	// 每个用户约每25ms一次
+	var total int64
+	for i := range calls {
+		assert.InDelta(t, 8, calls[i].Load(), 3)
+		total += calls[i].Load()
+	}
+	assert.Equal(t, total, fired)
+}
+
+func TestRunPhase(t *testing.T) {
+	var calls, inFlight, maxInFlight atomic.Int64
+	fire := func(ctx context.Context) {
+		n := inFlight.Add(1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		calls.Add(1)
+		time.Sleep(5 * time.Millisecond)
+		inFlight.Add(-1)
+	}
+	// 限速：200ms内按每秒100个发出约20个
+	fired := RunPhase(context.Background(), LoadPhase{Duration: 200 * time.Millisecond, Rate: 100, Concurrency: 4}, nil, fire)
+	assert.Equal(t, fired, calls.Load())
+	assert.InDelta(t, 20, fired, 5)
+	assert.LessOrEqual(t, maxInFlight.Load(), int64(4))
+
+	// 不限速时并发数等于worker数
+	calls.Store(0)
+	maxInFlight.Store(0)
+	fired = RunPhase(context.Background(), LoadPhase{Duration: 100 * time.Millisecond, Concurrency: 3}, nil, fire)
+	assert.Equal(t, fired, calls.Load())
+	assert.Equal(t, int64(3), maxInFlight.Load())
+}
+
+func TestRunSessions(t *testing.T) {
+	registry := NewMetricsRegistry()
+	stats := registry.Stats(Labels{Endpoint: models.SessionEndpoint})
+	sessions := RunSessions(context.Background(), 50*time.Millisecond, 4, func(ctx context.Context, user int) {
+		if user%2 == 0 {
+			stats.Record(OutcomeSuccess, uint64(time.Duration(user+1)*time.Millisecond))
+			return
+		}
+		// 奇数用户一直等到阶段结束
+		<-ctx.Done()
+		stats.RecordWithReason(OutcomeRejected, uint64(50*time.Millisecond), "phase ended")
+	})
+	assert.Equal(t, int64(4), sessions)
+	report := SessionReport(stats)
+	assert.Contains(t, report, "sessions: 4, success rate 50.00%")
+	assert.Contains(t, report, "time to purchase")
+	assert.Contains(t, report, "phase ended: 2")
+}
+
+func TestRecordSession(t *testing.T) {
+	registry := NewMetricsRegistry()
+	stats := registry.Stats(Labels{Endpoint: models.SessionEndpoint})
+	start := time.Now()
+	RecordSession(stats, start, start.Add(200*time.Millisecond), start.Add(time.Second))
+	// 没有抢到的会话不计入成功，也不计入完成抢购的时间
+	RecordSession(stats, start, time.Time{}, start.Add(10*time.Second))
+
+	assert.Equal(t, uint64(1), stats.Count(OutcomeSuccess))
+	assert.Equal(t, uint64(200*time.Millisecond), stats.Latency(OutcomeSuccess).Max)
+	assert.Equal(t, uint64(1), stats.Count(OutcomeRejected))
+	report := SessionReport(stats)
+	assert.Contains(t, report, "sessions: 2, success rate 50.00%")
+	assert.Contains(t, report, "no purchase: 1")
+}
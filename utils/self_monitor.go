@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// GeneratorSource 压测客户端自身采样在时间线中的数据源名称
+const GeneratorSource = "generator"
+
+// SelfMonitor 采样压测客户端进程自身的资源使用，判断瓶颈是否在客户端
+type SelfMonitor struct {
+	// 等待并发槽位的耗时
+	SemaphoreWait *Histogram
+
+	// 超过以下阈值时认为客户端可能是瓶颈
+	MaxCPUPercent       float64
+	MaxGCPausePercent   float64
+	MaxFDPercent        float64
+	MaxPortPercent      float64
+	MaxSemaphoreWaitP99 time.Duration
+
+	mu        sync.Mutex
+	lastWall  time.Time
+	lastCPU   time.Duration
+	lastPause uint64
+	lastNumGC uint32
+	portLow   int
+	portHigh  int
+}
+
+func NewSelfMonitor() *SelfMonitor {
+	m := &SelfMonitor{
+		SemaphoreWait:       NewHistogram(),
+		MaxCPUPercent:       85,
+		MaxGCPausePercent:   5,
+		MaxFDPercent:        80,
+		MaxPortPercent:      80,
+		MaxSemaphoreWaitP99: 100 * time.Millisecond,
+		portLow:             32768,
+		portHigh:            60999,
+	}
+	if low, high, err := ephemeralPortRange(); err == nil {
+		m.portLow, m.portHigh = low, high
+	}
+	m.lastWall = time.Now()
+	m.lastCPU, _ = processCPUTime()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	m.lastPause, m.lastNumGC = memStats.PauseTotalNs, memStats.NumGC
+	return m
+}
+
+func (m *SelfMonitor) RecordSemaphoreWait(d time.Duration) {
+	m.SemaphoreWait.Record(uint64(d.Nanoseconds()))
+}
+
+// Sample 采集一次进程指标，CPU和GC为距上次采样的增量
+func (m *SelfMonitor) Sample() (map[string]float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	wall := now.Sub(m.lastWall)
+	values := map[string]float64{
+		"goroutines":              float64(runtime.NumGoroutine()),
+		"semaphore_wait_p99_ms":   float64(m.SemaphoreWait.Quantile(0.99)) / 1e6,
+		"semaphore_wait_total_ms": float64(m.SemaphoreWait.Sum()) / 1e6,
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	pause := memStats.PauseTotalNs - m.lastPause
+	values["heap_mb"] = float64(memStats.HeapAlloc) / (1 << 20)
+	values["gc_count"] = float64(memStats.NumGC - m.lastNumGC)
+	values["gc_pause_ms"] = float64(pause) / 1e6
+	if wall > 0 {
+		values["gc_pause_percent"] = 100 * float64(pause) / float64(wall.Nanoseconds())
+	}
+	m.lastPause, m.lastNumGC = memStats.PauseTotalNs, memStats.NumGC
+
+	var errs []string
+	if cpu, err := processCPUTime(); err == nil {
+		if wall > 0 {
+			// 占所有核心的百分比
+			values["cpu_percent"] = 100 * float64(cpu-m.lastCPU) / float64(wall) / float64(runtime.NumCPU())
+		}
+		m.lastCPU = cpu
+	} else {
+		errs = append(errs, err.Error())
+	}
+	m.lastWall = now
+
+	if fds, err := openFDs(); err == nil {
+		values["open_fds"] = float64(fds)
+	} else {
+		errs = append(errs, err.Error())
+	}
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+		values["fd_limit"] = float64(limit.Cur)
+	}
+	if ports, err := m.ephemeralPortsInUse(); err == nil {
+		values["ephemeral_ports"] = float64(ports)
+		values["ephemeral_port_range"] = float64(m.portHigh - m.portLow + 1)
+	} else {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return values, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return values, nil
+}
+
+// Warnings 根据采样结果判断客户端是否已经饱和
+func (m *SelfMonitor) Warnings(ts *TimeSeries) []string {
+	summaries := ts.Summary(GeneratorSource)
+	warnings := make([]string, 0)
+	if s, ok := summaries["cpu_percent"]; ok && s.Max > m.MaxCPUPercent {
+		warnings = append(warnings, fmt.Sprintf("client CPU reached %.1f%% of all cores", s.Max))
+	}
+	if s, ok := summaries["gc_pause_percent"]; ok && s.Max > m.MaxGCPausePercent {
+		warnings = append(warnings, fmt.Sprintf("client GC pauses took %.1f%% of wall time", s.Max))
+	}
+	if fds, ok := summaries["open_fds"]; ok {
+		if limit, ok := summaries["fd_limit"]; ok && limit.Last > 0 && 100*fds.Max/limit.Last > m.MaxFDPercent {
+			warnings = append(warnings, fmt.Sprintf("client open fds reached %.0f of limit %.0f", fds.Max, limit.Last))
+		}
+	}
+	if ports, ok := summaries["ephemeral_ports"]; ok {
+		if total, ok := summaries["ephemeral_port_range"]; ok && total.Last > 0 && 100*ports.Max/total.Last > m.MaxPortPercent {
+			warnings = append(warnings, fmt.Sprintf("ephemeral ports in use reached %.0f of %.0f", ports.Max, total.Last))
+		}
+	}
+	if p99 := time.Duration(m.SemaphoreWait.Quantile(0.99)); p99 > m.MaxSemaphoreWaitP99 {
+		warnings = append(warnings, fmt.Sprintf("workers waited %v (p99) for a concurrency slot, max_concurrency limits the load", p99))
+	}
+	return warnings
+}
+
+func (m *SelfMonitor) Report(ts *TimeSeries) string {
+	var sb strings.Builder
+	sb.WriteString(ts.FormatSummary(GeneratorSource))
+	sb.WriteString(fmt.Sprintf("  semaphore wait(ms): p50 %.2f, p99 %.2f, max %.2f\n",
+		float64(m.SemaphoreWait.Quantile(0.5))/1e6, float64(m.SemaphoreWait.Quantile(0.99))/1e6, float64(m.SemaphoreWait.Max())/1e6))
+	for _, w := range m.Warnings(ts) {
+		sb.WriteString("  WARNING: " + w + ", the client may be the bottleneck\n")
+	}
+	return sb.String()
+}
+
+func processCPUTime() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
+
+func openFDs() (int, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+func ephemeralPortRange() (int, int, error) {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("invalid ip_local_port_range: %q", data)
+	}
+	low, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	high, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return low, high, nil
+}
+
+// ephemeralPortsInUse 统计本机本地端口在临时端口范围内的TCP连接数（含TIME_WAIT）
+func (m *SelfMonitor) ephemeralPortsInUse() (int, error) {
+	count := 0
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		file, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // 跳过表头
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			// fields[1]为 本地地址:端口，fields[3]为状态，0A表示LISTEN
+			if len(fields) < 4 || fields[3] == "0A" {
+				continue
+			}
+			idx := strings.LastIndexByte(fields[1], ':')
+			port, err := strconv.ParseInt(fields[1][idx+1:], 16, 32)
+			if err != nil {
+				continue
+			}
+			if int(port) >= m.portLow && int(port) <= m.portHigh {
+				count++
+			}
+		}
+		err = scanner.Err()
+		_ = file.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
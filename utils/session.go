@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunSessions 启动users个虚拟用户，每个用户调用一次fire执行完整的会话，返回完成的会话数。
+// fire收到的ctx在duration结束时取消，会话应在步骤之间检查ctx并放弃
+func RunSessions(ctx context.Context, duration time.Duration, users int, fire func(ctx context.Context, user int)) int64 {
+	phaseCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	var sessions atomic.Int64
+	wg := &sync.WaitGroup{}
+	for user := range max(users, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fire(phaseCtx, user)
+			sessions.Add(1)
+		}()
+	}
+	wg.Wait()
+	return sessions.Load()
+}
+
+// RecordSession 记录一次走完所有步骤的会话：有purchase成功时记为success，延迟为从会话开始到purchase成功的时间；
+// 没有抢到时记为rejected，延迟为会话时长，避免没有下单的会话计入完成抢购的时间
+func RecordSession(stats *RequestStats, start, purchased, end time.Time) {
+	if purchased.IsZero() {
+		stats.RecordWithReason(OutcomeRejected, uint64(end.Sub(start).Nanoseconds()), "no purchase")
+		return
+	}
+	stats.Record(OutcomeSuccess, uint64(purchased.Sub(start).Nanoseconds()))
+}
+
+// SessionReport 会话统计：成功的会话按完成抢购的时间记录延迟，放弃的会话按原因记为rejected
+func SessionReport(stats *RequestStats) string {
+	success := stats.Count(OutcomeSuccess)
+	total := success + stats.Count(OutcomeRejected) + stats.Count(OutcomeError)
+	report := fmt.Sprintf("sessions: %d, success rate %.2f%%", total, float64(success)*100/float64(max(total, 1)))
+	if success > 0 {
+		latency := stats.Latency(OutcomeSuccess)
+		ms := func(q float64) float64 { return float64(latency.Quantile(q)) / 1e6 }
+		report += fmt.Sprintf("\ntime to purchase: p50 %.2fms, p90 %.2fms, p99 %.2fms", ms(0.5), ms(0.9), ms(0.99))
+	}
+	if reasons := stats.formatReasons(); reasons != "" {
+		report += "\n" + strings.TrimSuffix(reasons, "\n")
+	}
+	return report
+}
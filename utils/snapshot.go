@@ -0,0 +1,307 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"hmdp-go-test/models"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 与MySQL DATETIME文本格式一致，连接使用parseTime时时间列会被解析为time.Time
+const mysqlTimeLayout = "2006-01-02 15:04:05.999999"
+
+// TableSpec 需要快照的表，Where为空表示整张表
+type TableSpec struct {
+	Name  string
+	Where string
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func tableCondition(where string) string {
+	if where == "" {
+		return ""
+	}
+	return " where " + where
+}
+
+// sqlText 把驱动返回的值转换为MySQL可以原样写回的文本，不是合法UTF-8的[]byte按base64编码并返回true，
+// 否则JSON编码时会被替换为U+FFFD
+func sqlText(v any) (*string, bool) {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, false
+	case []byte:
+		if !utf8.Valid(v) {
+			s = base64.StdEncoding.EncodeToString(v)
+			return &s, true
+		}
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	case time.Time:
+		s = v.Format(mysqlTimeLayout)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return &s, false
+}
+
+// rowArgs 把快照中的第i行转换为insert参数，binary为base64编码的 [行, 列]
+func rowArgs(table models.TableSnapshot, i int, binary map[[2]int]bool) ([]any, error) {
+	args := make([]any, len(table.Rows[i]))
+	for j, v := range table.Rows[i] {
+		switch {
+		case v == nil:
+			args[j] = nil
+		case binary[[2]int{i, j}]:
+			data, err := base64.StdEncoding.DecodeString(*v)
+			if err != nil {
+				return nil, fmt.Errorf("decode %s.%s row %d error: %w", table.Name, table.Columns[j], i, err)
+			}
+			args[j] = data
+		default:
+			args[j] = *v
+		}
+	}
+	return args, nil
+}
+
+// SnapshotTable 读取表中满足spec.Where的所有行
+func SnapshotTable(ctx context.Context, db *sql.DB, spec TableSpec) (models.TableSnapshot, error) {
+	snapshot := models.TableSnapshot{Name: spec.Name, Where: spec.Where, Rows: make([][]*string, 0)}
+	rows, err := db.QueryContext(ctx, "select * from "+quoteIdentifier(spec.Name)+tableCondition(spec.Where))
+	if err != nil {
+		return snapshot, fmt.Errorf("snapshot %s error: %w", spec.Name, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	snapshot.Columns, err = rows.Columns()
+	if err != nil {
+		return snapshot, err
+	}
+	values := make([]any, len(snapshot.Columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return snapshot, fmt.Errorf("snapshot %s error: %w", spec.Name, err)
+		}
+		row := make([]*string, len(values))
+		for i, v := range values {
+			var binary bool
+			row[i], binary = sqlText(v)
+			if binary {
+				snapshot.Binary = append(snapshot.Binary, [2]int{len(snapshot.Rows), i})
+			}
+		}
+		snapshot.Rows = append(snapshot.Rows, row)
+	}
+	return snapshot, rows.Err()
+}
+
+// RestoreTables 在一个事务中删除每张表当前满足Where的行，再写回快照中的行，所有语句经过guard检查和审计，插入语句的审计不含行数据
+func RestoreTables(ctx context.Context, db *sql.DB, guard *Guard, tables []models.TableSnapshot, chunkSize int) error {
+	chunkSize = max(chunkSize, 1)
+	if err := guard.AllowMySQL(); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, table := range tables {
+		name := quoteIdentifier(table.Name)
+		if _, err := guard.Exec(ctx, tx, "delete from "+name+tableCondition(table.Where)); err != nil {
+			return fmt.Errorf("clear %s error: %w", table.Name, err)
+		}
+		columns := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			columns[i] = quoteIdentifier(column)
+		}
+		binary := make(map[[2]int]bool, len(table.Binary))
+		for _, cell := range table.Binary {
+			binary[cell] = true
+		}
+		row := "(" + placeholders(len(columns)) + ")"
+		for start := 0; start < len(table.Rows); start += chunkSize {
+			end := min(start+chunkSize, len(table.Rows))
+			values := make([]string, 0, end-start)
+			args := make([]interface{}, 0, (end-start)*len(columns))
+			for i := start; i < end; i++ {
+				values = append(values, row)
+				rowValues, err := rowArgs(table, i, binary)
+				if err != nil {
+					return err
+				}
+				args = append(args, rowValues...)
+			}
+			insert := "insert into " + name + " (" + strings.Join(columns, ", ") + ") values "
+			// 行数据可能包含用户信息，审计日志只记录插入的行数
+			statement := fmt.Sprintf("%s/* %d rows */", insert, end-start)
+			if _, err := guard.ExecSummary(ctx, tx, statement, insert+strings.Join(values, ", "), args...); err != nil {
+				return fmt.Errorf("restore %s error: %w", table.Name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// matchRedisKeys 返回匹配patterns的所有key，不含通配符的模式只在key存在时返回
+func matchRedisKeys(ctx context.Context, client redis.UniversalClient, patterns []string, count int64) ([]string, error) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	collect := func(keys []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range keys {
+			seen[key] = true
+		}
+		return nil
+	}
+	for _, pattern := range patterns {
+		if isGlobPattern(pattern) {
+			if err := ScanKeys(ctx, client, pattern, count, collect); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		exists, err := client.Exists(ctx, pattern).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists > 0 {
+			_ = collect([]string{pattern})
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// SnapshotRedisKeys 用DUMP和PTTL保存匹配patterns的key，快照期间过期的key会被跳过
+func SnapshotRedisKeys(ctx context.Context, client redis.UniversalClient, patterns []string, batchSize int) (models.RedisSnapshot, error) {
+	batchSize = max(batchSize, 1)
+	snapshot := models.RedisSnapshot{Patterns: patterns, Keys: make([]models.RedisKeySnapshot, 0)}
+	keys, err := matchRedisKeys(ctx, client, patterns, int64(batchSize))
+	if err != nil {
+		return snapshot, err
+	}
+	for start := 0; start < len(keys); start += batchSize {
+		batch := keys[start:min(start+batchSize, len(keys))]
+		pipe := client.Pipeline()
+		dumps := make([]*redis.StringCmd, len(batch))
+		ttls := make([]*redis.DurationCmd, len(batch))
+		for i, key := range batch {
+			dumps[i] = pipe.Dump(ctx, key)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return snapshot, fmt.Errorf("dump redis keys error: %w", err)
+		}
+		for i, key := range batch {
+			dump, err := dumps[i].Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return snapshot, fmt.Errorf("dump %s error: %w", key, err)
+			}
+			entry := models.RedisKeySnapshot{Key: key, Dump: base64.StdEncoding.EncodeToString([]byte(dump))}
+			// PTTL为负数表示没有过期时间
+			if ttl := ttls[i].Val(); ttl > 0 {
+				entry.TTLMillis = ttl.Milliseconds()
+			}
+			snapshot.Keys = append(snapshot.Keys, entry)
+		}
+	}
+	return snapshot, nil
+}
+
+// RestoreRedisKeys 删除当前匹配快照模式的key后用RESTORE写回快照中的key，操作经过guard检查和审计
+func RestoreRedisKeys(ctx context.Context, client redis.UniversalClient, guard *Guard, snapshot models.RedisSnapshot, batchSize int) error {
+	batchSize = max(batchSize, 1)
+	if err := guard.AllowRedis(); err != nil {
+		return err
+	}
+	results, err := CleanupRedisKeys(ctx, client, snapshot.Patterns, batchSize, false)
+	for _, result := range results {
+		guard.Record(guard.RedisTarget(), "UNLINK "+result.Pattern, nil, result.Deleted, nil)
+	}
+	if err != nil {
+		guard.Record(guard.RedisTarget(), "UNLINK", nil, 0, err)
+		return err
+	}
+	for start := 0; start < len(snapshot.Keys); start += batchSize {
+		batch := snapshot.Keys[start:min(start+batchSize, len(snapshot.Keys))]
+		pipe := client.Pipeline()
+		keys := make([]any, len(batch))
+		for i, entry := range batch {
+			dump, err := base64.StdEncoding.DecodeString(entry.Dump)
+			if err != nil {
+				return fmt.Errorf("decode %s error: %w", entry.Key, err)
+			}
+			pipe.RestoreReplace(ctx, entry.Key, time.Duration(entry.TTLMillis)*time.Millisecond, string(dump))
+			keys[i] = entry.Key
+		}
+		_, err := pipe.Exec(ctx)
+		guard.Record(guard.RedisTarget(), "RESTORE REPLACE", keys, int64(len(batch)), err)
+		if err != nil {
+			return fmt.Errorf("restore redis keys error: %w", err)
+		}
+	}
+	return nil
+}
+
+// WriteSnapshot 原子地写入快照文件
+func WriteSnapshot(path string, snapshot *models.Snapshot) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshot)
+	})
+}
+
+func ReadSnapshot(path string) (*models.Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot models.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("parse snapshot %s error: %w", path, err)
+	}
+	if snapshot.Version < 1 || snapshot.Version > models.SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"hmdp-go-test/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotBinaryRoundTrip(t *testing.T) {
+	created := time.Date(2026, 10, 19, 10, 0, 0, 123000000, time.Local)
+	payload := []byte{0x00, 0xff, 0xfe, 'o', 'k', 0x80}
+	rows := [][]any{
+		{int64(1), []byte("普通文本"), payload, nil, created},
+		{int64(2), []byte{}, []byte{0xc3, 0x28}, []byte("{\"a\":1}"), created},
+	}
+	table := models.TableSnapshot{Name: "tb_message", Columns: []string{"id", "topic", "payload", "extra", "create_time"}}
+	for i, values := range rows {
+		row := make([]*string, len(values))
+		for j, v := range values {
+			var binary bool
+			row[j], binary = sqlText(v)
+			if binary {
+				table.Binary = append(table.Binary, [2]int{i, j})
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	assert.Equal(t, [][2]int{{0, 2}, {1, 2}}, table.Binary)
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	err := WriteSnapshot(path, &models.Snapshot{Version: models.SnapshotVersion, CreatedAt: created, Tables: []models.TableSnapshot{table}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := snapshot.Tables[0]
+	binary := make(map[[2]int]bool)
+	for _, cell := range restored.Binary {
+		binary[cell] = true
+	}
+	for i := range rows {
+		args, err := rowArgs(restored, i, binary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, fmt.Sprint(rows[i][0]), args[0])
+		assert.Equal(t, string(rows[i][1].([]byte)), args[1])
+		// 二进制值逐字节写回
+		assert.Equal(t, rows[i][2], args[2])
+		if rows[i][3] == nil {
+			assert.Nil(t, args[3])
+		} else {
+			assert.Equal(t, string(rows[i][3].([]byte)), args[3])
+		}
+		assert.Equal(t, "2026-10-19 10:00:00.123", args[4])
+	}
+}
+
+func TestReadSnapshotVersion(t *testing.T) {
+	dir := t.TempDir()
+	// v1没有binary字段，仍然可以读取
+	v1 := filepath.Join(dir, "v1.json")
+	assert.NoError(t, os.WriteFile(v1, []byte(`{"version":1,"tables":[{"name":"t","columns":["id"],"rows":[["1"]]}],"redis":{}}`), 0644))
+	snapshot, err := ReadSnapshot(v1)
+	if assert.NoError(t, err) {
+		assert.Empty(t, snapshot.Tables[0].Binary)
+	}
+	future := filepath.Join(dir, "v9.json")
+	assert.NoError(t, os.WriteFile(future, []byte(`{"version":9}`), 0644))
+	_, err = ReadSnapshot(future)
+	assert.ErrorContains(t, err, "unsupported snapshot version")
+}
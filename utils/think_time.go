@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"hmdp-go-test/models"
+	"math/rand/v2"
+	"time"
+)
+
+// ThinkTime 用户两次请求之间的思考时间分布
+type ThinkTime interface {
+	Next() time.Duration
+}
+
+// ConstantThinkTime 固定的思考时间
+type ConstantThinkTime time.Duration
+
+func (c ConstantThinkTime) Next() time.Duration {
+	return time.Duration(c)
+}
+
+// UniformThinkTime 在[Min, Max]之间均匀分布
+type UniformThinkTime struct {
+	Min, Max time.Duration
+}
+
+func (u UniformThinkTime) Next() time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + rand.N(u.Max-u.Min+1)
+}
+
+// clamp 把d限制在[lower, upper]之间，upper为0表示没有上界
+func clamp(d, lower, upper time.Duration) time.Duration {
+	d = max(d, lower)
+	if upper > 0 {
+		d = min(d, upper)
+	}
+	return d
+}
+
+// ExponentialThinkTime 均值为Mean的指数分布，结果限制在[Min, Max]之间，Max为0表示没有上界
+type ExponentialThinkTime struct {
+	Mean, Min, Max time.Duration
+}
+
+func (e ExponentialThinkTime) Next() time.Duration {
+	return clamp(time.Duration(rand.ExpFloat64()*float64(e.Mean)), e.Min, e.Max)
+}
+
+// NormalThinkTime 均值为Mean、标准差为Stddev的正态分布，超出[Min, Max]时重新采样，多次超出后截断
+type NormalThinkTime struct {
+	Mean, Stddev, Min, Max time.Duration
+}
+
+func (n NormalThinkTime) Next() time.Duration {
+	var d time.Duration
+	for range 8 {
+		d = n.Mean + time.Duration(rand.NormFloat64()*float64(n.Stddev))
+		if d >= n.Min && (n.Max == 0 || d <= n.Max) {
+			return d
+		}
+	}
+	return clamp(d, n.Min, n.Max)
+}
+
+// NewThinkTime 根据配置创建思考时间分布
+func NewThinkTime(config models.ThinkTimeConfig) (ThinkTime, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	ms := func(v int) time.Duration { return time.Duration(v) * time.Millisecond }
+	switch config.Distribution {
+	case models.ThinkTimeConstant:
+		return ConstantThinkTime(ms(config.Ms)), nil
+	case models.ThinkTimeExponential:
+		return ExponentialThinkTime{Mean: ms(config.MeanMs), Min: ms(config.MinMs), Max: ms(config.MaxMs)}, nil
+	case models.ThinkTimeNormal:
+		return NormalThinkTime{Mean: ms(config.MeanMs), Stddev: ms(config.StddevMs), Min: ms(config.MinMs), Max: ms(config.MaxMs)}, nil
+	default:
+		return UniformThinkTime{Min: ms(config.MinMs), Max: ms(config.MaxMs)}, nil
+	}
+}
+
+// SleepContext 等待d或ctx结束，ctx结束时返回false
+func SleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"hmdp-go-test/models"
+	"testing"
+	"time"
+)
+
+func TestNewThinkTime(t *testing.T) {
+	constant, err := NewThinkTime(models.ThinkTimeConfig{Distribution: models.ThinkTimeConstant, Ms: 500})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 500*time.Millisecond, constant.Next())
+	}
+
+	configs := []models.ThinkTimeConfig{
+		{MinMs: 100, MaxMs: 200},
+		{Distribution: models.ThinkTimeExponential, MeanMs: 150, MinMs: 100, MaxMs: 200},
+		{Distribution: models.ThinkTimeNormal, MeanMs: 150, StddevMs: 100, MinMs: 100, MaxMs: 200},
+	}
+	for _, config := range configs {
+		think, err := NewThinkTime(config)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		for range 1000 {
+			d := think.Next()
+			assert.GreaterOrEqual(t, d, 100*time.Millisecond, config.Distribution)
+			assert.LessOrEqual(t, d, 200*time.Millisecond, config.Distribution)
+		}
+	}
+
+	// 没有上界时指数分布的均值接近MeanMs
+	exponential, _ := NewThinkTime(models.ThinkTimeConfig{Distribution: models.ThinkTimeExponential, MeanMs: 100})
+	var total time.Duration
+	for range 10000 {
+		total += exponential.Next()
+	}
+	assert.InDelta(t, 100, float64(total/10000)/float64(time.Millisecond), 10)
+
+	_, err = NewThinkTime(models.ThinkTimeConfig{Distribution: models.ThinkTimeNormal, StddevMs: 10})
+	assert.ErrorContains(t, err, "mean_ms")
+	_, err = NewThinkTime(models.ThinkTimeConfig{Distribution: "pareto"})
+	assert.ErrorContains(t, err, "unknown")
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample 某一时刻一个数据源的采样值
+type Sample struct {
+	Time   time.Time
+	Source string
+	Values map[string]float64
+}
+
+// TimeSeries 一次运行中各数据源的采样时间线，客户端和服务端的采样放在同一条时间线上便于对照
+type TimeSeries struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+func NewTimeSeries() *TimeSeries {
+	return &TimeSeries{}
+}
+
+func (ts *TimeSeries) Add(source string, t time.Time, values map[string]float64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.samples = append(ts.samples, Sample{Time: t, Source: source, Values: values})
+}
+
+// Samples 按时间顺序返回数据源的采样，source为空时返回所有采样
+func (ts *TimeSeries) Samples(source string) []Sample {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	samples := make([]Sample, 0, len(ts.samples))
+	for _, s := range ts.samples {
+		if source == "" || s.Source == source {
+			samples = append(samples, s)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples
+}
+
+// Latest 返回每个数据源最后一次采样
+func (ts *TimeSeries) Latest() map[string]Sample {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	latest := make(map[string]Sample)
+	for _, s := range ts.samples {
+		if last, ok := latest[s.Source]; !ok || !s.Time.Before(last.Time) {
+			latest[s.Source] = s
+		}
+	}
+	return latest
+}
+
+// StartSampler 每隔interval调用一次fn并记录结果，返回的stop函数会在记录最后一次采样后返回
+func (ts *TimeSeries) StartSampler(source string, interval time.Duration, fn func() (map[string]float64, error)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	sample := func() {
+		values, err := fn()
+		if err != nil {
+			fmt.Printf("sample %s error: %v\n", source, err)
+		}
+		if len(values) > 0 {
+			ts.Add(source, time.Now(), values)
+		}
+	}
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				sample()
+				return
+			case <-ticker.C:
+				sample()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+type SeriesSummary struct {
+	Min, Max, Avg, Last float64
+	Count               int
+}
+
+// Summary 汇总数据源每个指标的最小、最大、平均和最后一次采样值
+func (ts *TimeSeries) Summary(source string) map[string]SeriesSummary {
+	summaries := make(map[string]SeriesSummary)
+	for _, s := range ts.Samples(source) {
+		for key, v := range s.Values {
+			summary, ok := summaries[key]
+			if !ok {
+				summary = SeriesSummary{Min: math.Inf(1), Max: math.Inf(-1)}
+			}
+			summary.Min = min(summary.Min, v)
+			summary.Max = max(summary.Max, v)
+			summary.Avg = (summary.Avg*float64(summary.Count) + v) / float64(summary.Count+1)
+			summary.Last = v
+			summary.Count++
+			summaries[key] = summary
+		}
+	}
+	return summaries
+}
+
+func (ts *TimeSeries) FormatSummary(source string) string {
+	summaries := ts.Summary(source)
+	keys := make([]string, 0, len(summaries))
+	for k := range summaries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s:\n", source))
+	for _, k := range keys {
+		s := summaries[k]
+		sb.WriteString(fmt.Sprintf("  %s: min %.2f, avg %.2f, max %.2f, last %.2f\n", k, s.Min, s.Avg, s.Max, s.Last))
+	}
+	return sb.String()
+}
+
+// WriteCSV 以 time,source,metric,value 的长表格式导出整条时间线
+func (ts *TimeSeries) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"time", "source", "metric", "value"}); err != nil {
+		return err
+	}
+	for _, s := range ts.Samples("") {
+		keys := make([]string, 0, len(s.Values))
+		for k := range s.Values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			record := []string{s.Time.Format(time.RFC3339Nano), s.Source, k, strconv.FormatFloat(s.Values[k], 'f', -1, 64)}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// TokenTTLs 通过pipeline批量查询token在Redis中的剩余有效期，key不存在时为负数
+func TokenTTLs(ctx context.Context, client redis.UniversalClient, prefix string, tokens []string, batchSize int) ([]time.Duration, error) {
+	ttls := make([]time.Duration, 0, len(tokens))
+	batchSize = max(batchSize, 1)
+	for start := 0; start < len(tokens); start += batchSize {
+		batch := tokens[start:min(start+batchSize, len(tokens))]
+		pipe := client.Pipeline()
+		cmds := make([]*redis.DurationCmd, len(batch))
+		for i, token := range batch {
+			cmds[i] = pipe.TTL(ctx, prefix+token)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+		for _, cmd := range cmds {
+			ttls = append(ttls, cmd.Val())
+		}
+	}
+	return ttls, nil
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hmdp-go-test/models"
+	"sync"
+)
+
+// UserLookup 按手机号分批并发查询tb_user，避免单条 IN (...) 超过MySQL的占位符上限
+type UserLookup struct {
+	db          *sql.DB
+	chunkSize   int
+	concurrency int
+}
+
+func NewUserLookup(db *sql.DB, chunkSize int, concurrency int) *UserLookup {
+	return &UserLookup{
+		db:          db,
+		chunkSize:   max(chunkSize, 1),
+		concurrency: max(concurrency, 1),
+	}
+}
+
+// LookupByPhones 查询手机号对应的用户，不存在的手机号不会出现在结果中
+func (l *UserLookup) LookupByPhones(ctx context.Context, phones []string) ([]models.User, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	users := make([]models.User, 0, len(phones))
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, l.concurrency)
+	for start := 0; start < len(phones); start += l.chunkSize {
+		chunk := phones[start:min(start+l.chunkSize, len(phones))]
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			chunkUsers, err := l.lookupChunk(ctx, chunk)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			users = append(users, chunkUsers...)
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (l *UserLookup) lookupChunk(ctx context.Context, phones []string) ([]models.User, error) {
+	args := make([]interface{}, len(phones))
+	for i, phone := range phones {
+		args[i] = phone
+	}
+	query := fmt.Sprintf("select id, phone, nick_name, icon from tb_user where phone in (%s)", placeholders(len(phones)))
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query users error: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	users := make([]models.User, 0, len(phones))
+	for rows.Next() {
+		var user models.User
+		var nickName, icon sql.NullString
+		if err := rows.Scan(&user.ID, &user.Phone, &nickName, &icon); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		user.NickName = nickName.String
+		user.Icon = icon.String
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query users error: %w", err)
+	}
+	return users, nil
+}
+
+// LookupPool 返回用户池中所有用户，只查询池中还没有UserID的手机号，并把查到的ID写回pool，
+// 返回值表示pool是否被更新
+func (l *UserLookup) LookupPool(ctx context.Context, pool map[string]models.AuthEntry) ([]models.User, bool, error) {
+	users := make([]models.User, 0, len(pool))
+	missing := make([]string, 0)
+	for phone, entry := range pool {
+		if entry.UserID != 0 {
+			users = append(users, entry.User())
+		} else {
+			missing = append(missing, phone)
+		}
+	}
+	if len(missing) == 0 {
+		return users, false, nil
+	}
+	found, err := l.LookupByPhones(ctx, missing)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, user := range found {
+		entry := pool[user.Phone]
+		entry.UserID = user.ID
+		pool[user.Phone] = entry
+		user.Auth = entry.Token
+		users = append(users, user)
+	}
+	return users, len(found) > 0, nil
+}